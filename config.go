@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gacache"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//entry_overhead为measure的时候测量使用的key和value的大小
+const (
+	measureKeyLen   = 16
+	measureValueLen = 256
+)
+
+//测量需要两次完整的GC,每个进程只测量一次,所有Group和重新加载配置的时候共用
+var (
+	measureOnce      sync.Once
+	measuredOverhead int64
+)
+
+func measuredEntryOverhead() int64 {
+	measureOnce.Do(func() {
+		measuredOverhead = gacache.MeasureEntryOverhead(measureKeyLen, measureValueLen)
+	})
+	return measuredOverhead
+}
+
+//集群配置文件(JSON格式)
+//{
+//	"self": "http://localhost:8001",
+//	"api": "http://localhost:9999",
+//	"peers": ["http://localhost:8001", "http://localhost:8002"],
+//	"groups": [{
+//		"name": "scores",
+//		"cache_bytes": 2048,
+//		"ttl": "10m",
+//		"policy": "lru",
+//		"warmup": "scores.keys",
+//		"source": {"type": "map", "data": {"tom": "110"}}
+//	}]
+//}
+type Config struct {
+	Self   string        `json:"self"`  //当前节点的地址
+	API    string        `json:"api"`   //与用户交互的server地址,为空则不启动
+	Peers  []string      `json:"peers"` //所有节点的地址(包括自己)
+	Groups []GroupConfig `json:"groups"`
+}
+
+type GroupConfig struct {
+	Name          string       `json:"name"`
+	CacheBytes    int64        `json:"cache_bytes"`
+	HotCacheRatio *float64     `json:"hot_cache_ratio"` //为空则使用默认值
+	TTL           Duration     `json:"ttl"`
+	StaleTTL      Duration     `json:"stale_while_revalidate"` //软过期时间,需要小于ttl
+	Policy        string       `json:"policy"`                 //lru或者fifo,默认lru
+	WarmUp        string       `json:"warmup"`                 //启动时预热的key列表文件,每行一个key
+	DiskDir       string       `json:"disk_dir"`               //磁盘二级缓存的目录,数据保存在disk_dir/<name>
+	DiskBytes     int64        `json:"disk_bytes"`             //磁盘二级缓存的大小,0代表不开启
+	Compression   string       `json:"compression"`            //gzip或者deflate,为空则不压缩
+	CompressMin   int          `json:"compress_threshold"`     //大于等于该大小的数据才会压缩
+	ChunkSize     int          `json:"chunk_size"`             //超过该大小的数据分块存储,0代表不分块
+	MaxEntryBytes int64        `json:"max_entry_bytes"`        //单条数据的最大字节数,超过的不存入缓存,0代表不限制
+	EntryOverhead string       `json:"entry_overhead"`         //每条数据额外计算的内存: estimate,measure或者字节数,为空则不计算
+	Storage       string       `json:"storage"`                //heap或者arena,默认heap
+	Source        SourceConfig `json:"source"`
+}
+
+//Group的数据源
+type SourceConfig struct {
+	Type string            `json:"type"` //map,file或者http
+	Data map[string]string `json:"data"` //type为map时的数据
+	Path string            `json:"path"` //type为file时的JSON文件路径
+	URL  string            `json:"url"`  //type为http时的地址,请求时会在后面拼上key
+}
+
+//支持"10s","1m"这种格式的时间
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"10s\": %v", err)
+	}
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+//加载并校验配置文件,self不为空时覆盖配置文件中的self,方便多个节点共用一份配置
+func loadConfig(path string, self string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %v", err)
+	}
+	c := &Config{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("parse config %s: %v", path, err)
+	}
+	if self != "" {
+		c.Self = self
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) Validate() error {
+	if err := checkAddr(c.Self); err != nil {
+		return fmt.Errorf("self: %v", err)
+	}
+	if c.API != "" {
+		if err := checkAddr(c.API); err != nil {
+			return fmt.Errorf("api: %v", err)
+		}
+	}
+	if len(c.Peers) == 0 {
+		return fmt.Errorf("peers: empty")
+	}
+	hasSelf := false
+	seen := make(map[string]bool, len(c.Peers))
+	for i, peer := range c.Peers {
+		if err := checkAddr(peer); err != nil {
+			return fmt.Errorf("peers[%d]: %v", i, err)
+		}
+		if seen[peer] {
+			return fmt.Errorf("peers[%d]: duplicate peer %s", i, peer)
+		}
+		seen[peer] = true
+		hasSelf = hasSelf || peer == c.Self
+	}
+	if !hasSelf {
+		return fmt.Errorf("peers: self %s not in peers", c.Self)
+	}
+	if len(c.Groups) == 0 {
+		return fmt.Errorf("groups: empty")
+	}
+	names := make(map[string]bool, len(c.Groups))
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		if g.Name == "" {
+			return fmt.Errorf("groups[%d]: empty name", i)
+		}
+		if names[g.Name] {
+			return fmt.Errorf("groups[%d]: duplicate name %s", i, g.Name)
+		}
+		names[g.Name] = true
+		if _, err := g.buildOptions(false); err != nil {
+			return fmt.Errorf("groups[%d] %s: %v", i, g.Name, err)
+		}
+		if err := g.Source.validate(); err != nil {
+			return fmt.Errorf("groups[%d] %s: source: %v", i, g.Name, err)
+		}
+	}
+	return nil
+}
+
+//节点地址必须是完整的 协议/ip/port [eg. http://localhost:8001]
+func checkAddr(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" || u.Host == "" {
+		return fmt.Errorf("invalid address %q, should be like http://localhost:8001", addr)
+	}
+	return nil
+}
+
+//转换成gacache的Group配置
+func (g *GroupConfig) options() ([]gacache.GroupOption, error) {
+	return g.buildOptions(true)
+}
+
+//measure为false的时候只检查配置的语法,entry_overhead为measure的时候不测量
+func (g *GroupConfig) buildOptions(measure bool) ([]gacache.GroupOption, error) {
+	if g.CacheBytes < 0 {
+		return nil, fmt.Errorf("invalid cache_bytes %d", g.CacheBytes)
+	}
+	if g.TTL < 0 {
+		return nil, fmt.Errorf("invalid ttl %v", time.Duration(g.TTL))
+	}
+	if g.StaleTTL < 0 || (g.TTL > 0 && g.StaleTTL >= g.TTL) {
+		return nil, fmt.Errorf("invalid stale_while_revalidate %v, should be less than ttl", time.Duration(g.StaleTTL))
+	}
+	opts := []gacache.GroupOption{
+		gacache.WithTTL(time.Duration(g.TTL)),
+		gacache.WithStaleWhileRevalidate(time.Duration(g.StaleTTL)),
+	}
+	switch g.Policy {
+	case "", "lru":
+		opts = append(opts, gacache.WithEvictionPolicy(gacache.LRU))
+	case "fifo":
+		opts = append(opts, gacache.WithEvictionPolicy(gacache.FIFO))
+	default:
+		return nil, fmt.Errorf("unknown policy %q, should be lru or fifo", g.Policy)
+	}
+	if g.DiskBytes < 0 || (g.DiskBytes > 0 && g.DiskDir == "") {
+		return nil, fmt.Errorf("invalid disk_bytes %d, disk_dir is required", g.DiskBytes)
+	}
+	if g.DiskBytes > 0 {
+		opts = append(opts, gacache.WithDiskCache(g.DiskDir, g.DiskBytes))
+	}
+	if g.CompressMin < 0 {
+		return nil, fmt.Errorf("invalid compress_threshold %d", g.CompressMin)
+	}
+	switch g.Compression {
+	case "":
+	case "gzip":
+		opts = append(opts, gacache.WithCompression(gacache.Gzip, g.CompressMin))
+	case "deflate":
+		opts = append(opts, gacache.WithCompression(gacache.Deflate, g.CompressMin))
+	default:
+		return nil, fmt.Errorf("unknown compression %q, should be gzip or deflate", g.Compression)
+	}
+	if g.ChunkSize < 0 {
+		return nil, fmt.Errorf("invalid chunk_size %d", g.ChunkSize)
+	}
+	opts = append(opts, gacache.WithChunkSize(g.ChunkSize))
+	if g.MaxEntryBytes < 0 {
+		return nil, fmt.Errorf("invalid max_entry_bytes %d", g.MaxEntryBytes)
+	}
+	opts = append(opts, gacache.WithMaxEntryBytes(g.MaxEntryBytes))
+	switch g.Storage {
+	case "", "heap":
+	case "arena":
+		opts = append(opts, gacache.WithStorage(gacache.ArenaStorage))
+	default:
+		return nil, fmt.Errorf("unknown storage %q, should be heap or arena", g.Storage)
+	}
+	switch g.EntryOverhead {
+	case "":
+	case "estimate":
+		opts = append(opts, gacache.WithEntryOverhead(gacache.EntryOverhead))
+	case "measure":
+		if measure {
+			opts = append(opts, gacache.WithEntryOverhead(measuredEntryOverhead()))
+		}
+	default:
+		n, err := strconv.ParseInt(g.EntryOverhead, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid entry_overhead %q, should be estimate, measure or bytes", g.EntryOverhead)
+		}
+		opts = append(opts, gacache.WithEntryOverhead(n))
+	}
+	if g.HotCacheRatio != nil {
+		if r := *g.HotCacheRatio; r < 0 || r >= 1 {
+			return nil, fmt.Errorf("invalid hot_cache_ratio %v, should be in [0, 1)", r)
+		}
+		opts = append(opts, gacache.WithHotCacheRatio(*g.HotCacheRatio))
+	}
+	return opts, nil
+}
+
+func (s *SourceConfig) validate() error {
+	switch s.Type {
+	case "map":
+		return nil
+	case "file":
+		if s.Path == "" {
+			return fmt.Errorf("file source requires path")
+		}
+		return nil
+	case "http":
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid url %q", s.URL)
+		}
+		return nil
+	case "":
+		return fmt.Errorf("missing type")
+	}
+	return fmt.Errorf("unknown type %q, should be map, file or http", s.Type)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"gacache"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	c, err := loadConfig("cluster.json", "http://localhost:8002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Self != "http://localhost:8002" || len(c.Peers) != 3 || c.Groups[0].Name != "scores" {
+		t.Fatalf("unexpected config %+v", c)
+	}
+	if _, err := createGroups(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	const peers = `"self":"http://localhost:8001","peers":["http://localhost:8001"]`
+	testCase := []struct {
+		content string
+		expect  string
+	}{
+		{`{"self":"localhost:8001","peers":["localhost:8001"]}`, "self"},
+		{`{"self":"http://localhost:8001","peers":["http://localhost:8002"]}`, "not in peers"},
+		{`{` + peers + `,"groups":[]}`, "groups: empty"},
+		{`{` + peers + `,"groups":[{"ttl":1}]}`, "duration"},
+		{`{` + peers + `,"groups":[{"name":"a","policy":"lfu","source":{"type":"map"}}]}`, "unknown policy"},
+		{`{` + peers + `,"groups":[{"name":"a","source":{"type":"redis"}}]}`, "unknown type"},
+		{`{` + peers + `,"groups":[{"name":"a","source":{"type":"map"}},{"name":"a","source":{"type":"map"}}]}`, "duplicate name"},
+		{`{` + peers + `,"groups":[{"name":"a","entry_overhead":"guess","source":{"type":"map"}}]}`, "invalid entry_overhead"},
+	}
+	dir, err := ioutil.TempDir("", "gacache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	for _, c := range testCase {
+		if err := ioutil.WriteFile(path, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfig(path, ""); err == nil || !strings.Contains(err.Error(), c.expect) {
+			t.Errorf("config %s should fail with %q, but got %v", c.content, c.expect, err)
+		}
+	}
+}
+
+func TestMeasureEntryOverhead(t *testing.T) {
+	c := &Config{
+		Self:   "http://localhost:8001",
+		Peers:  []string{"http://localhost:8001"},
+		Groups: []GroupConfig{{Name: "a", EntryOverhead: "measure", Source: SourceConfig{Type: "map"}}},
+	}
+	//校验配置的时候不测量
+	if err := c.Validate(); err != nil || measuredOverhead != 0 {
+		t.Fatalf("validate should not measure, overhead %d err %v", measuredOverhead, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Groups[0].options(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if measuredOverhead <= 0 {
+		t.Fatalf("entry overhead should be measured once")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gacache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"self":"http://localhost:8001","peers":["http://localhost:8001","http://localhost:8002"],
+		"groups":[{"name":"reload","cache_bytes":1024,"source":{"type":"map","data":{"tom":"110"}}},
+		{"name":"reload3","source":{"type":"map"}},
+		{"name":"reload_arena","cache_bytes":1024,"storage":"arena","source":{"type":"map"}}]}`)
+	conf, err := loadConfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs, err := createGroups(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newReloader(path, "", conf, newPeers(conf.Self, conf.Peers, gs), gs)
+
+	write(`{"self":"http://localhost:8001","peers":["http://localhost:8001","http://localhost:8003"],
+		"groups":[{"name":"reload","cache_bytes":2048,"source":{"type":"map","data":{"tom":"110"}}},
+		{"name":"reload2","source":{"type":"map"}},
+		{"name":"reload_arena","cache_bytes":1024,"storage":"arena","source":{"type":"map"}}]}`)
+	expect := []string{
+		"peers: +http://localhost:8003",
+		"peers: -http://localhost:8002",
+		"group reload: cache_bytes 1024 -> 2048",
+		"groups: +reload2",
+		"groups: -reload3",
+	}
+	newConf, _ := loadConfig(path, "")
+	if changes := diffConfig(conf, newConf); !reflect.DeepEqual(changes, expect) {
+		t.Fatalf("expect changes %v, but got %v", expect, changes)
+	}
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if r.groups["reload2"] == nil || gacache.GetGroup("reload3") != nil || r.conf.Groups[0].CacheBytes != 2048 {
+		t.Fatalf("reload not applied")
+	}
+	//不合法的配置保留旧的配置
+	write(`{"self":"http://localhost:8001"}`)
+	if err := r.reload(); err == nil || r.conf.Groups[0].CacheBytes != 2048 {
+		t.Fatalf("invalid config should be rejected")
+	}
+
+	//中途失败的时候回滚已经做的修改,节点列表和配置都保持不变
+	peers := func() []string {
+		var addrs []string
+		for _, p := range r.peers.Peers() {
+			addrs = append(addrs, p.(fmt.Stringer).String())
+		}
+		return addrs
+	}
+	const newPeers = `"self":"http://localhost:8001","peers":["http://localhost:8001","http://localhost:8004"]`
+	for _, groups := range []string{
+		//新增的reload5创建失败
+		`{"name":"reload","cache_bytes":4096,"source":{"type":"map"}},{"name":"reload2","source":{"type":"map"}},
+		{"name":"reload_arena","cache_bytes":1024,"storage":"arena","source":{"type":"map"}},
+		{"name":"reload4","source":{"type":"map"}},{"name":"reload5","storage":"arena","source":{"type":"map"}}`,
+		//reload调整大小之后reload_arena调整失败,1byte放不下hotCache
+		`{"name":"reload","cache_bytes":4096,"source":{"type":"map"}},{"name":"reload2","source":{"type":"map"}},
+		{"name":"reload_arena","cache_bytes":1,"storage":"arena","source":{"type":"map"}},{"name":"reload4","source":{"type":"map"}}`,
+	} {
+		write(`{` + newPeers + `,"groups":[` + groups + `]}`)
+		if err := r.reload(); err == nil {
+			t.Fatalf("reload should fail")
+		}
+		if gacache.GetGroup("reload4") != nil || r.groups["reload4"] != nil || r.conf.Groups[0].CacheBytes != 2048 {
+			t.Fatalf("failed reload should be rolled back")
+		}
+		if addrs := peers(); len(addrs) != 1 || addrs[0] != "http://localhost:8003" {
+			t.Fatalf("peers should not change, got %v", addrs)
+		}
+	}
+	//之后的变更依然和旧的配置对比
+	write(`{` + newPeers + `,"groups":[{"name":"reload","cache_bytes":4096,"source":{"type":"map"}},
+		{"name":"reload2","cache_bytes":1024,"source":{"type":"map"}},
+		{"name":"reload_arena","cache_bytes":1024,"storage":"arena","source":{"type":"map"}}]}`)
+	newConf, _ = loadConfig(path, "")
+	expect = []string{
+		"peers: +http://localhost:8004",
+		"peers: -http://localhost:8003",
+		"group reload: cache_bytes 2048 -> 4096",
+		"group reload: source changed (requires restart)",
+		"group reload2: cache_bytes 0 -> 1024 (requires restart)",
+	}
+	if changes := diffConfig(r.conf, newConf); !reflect.DeepEqual(changes, expect) {
+		t.Fatalf("expect changes %v, but got %v", expect, changes)
+	}
+	if err := r.reload(); err != nil || r.conf.Groups[0].CacheBytes != 4096 {
+		t.Fatalf("reload should succeed: %v", err)
+	}
+	//需要重启的字段保留正在使用的值,再次加载依然提示需要重启
+	if r.conf.Groups[0].Source.Data["tom"] != "110" || r.conf.Groups[1].CacheBytes != 0 {
+		t.Fatalf("fields requiring restart should keep the old values, got %+v", r.conf.Groups)
+	}
+	expect = []string{
+		"group reload: source changed (requires restart)",
+		"group reload2: cache_bytes 0 -> 1024 (requires restart)",
+	}
+	if changes := diffConfig(r.conf, newConf); !reflect.DeepEqual(changes, expect) {
+		t.Fatalf("expect changes %v, but got %v", expect, changes)
+	}
+}
@@ -1,26 +1,87 @@
 package gacache
 
+import "time"
+
 //抽象一个只读的数据结构
 //[]byte是切片，传递都是直接传递的指针，需要避免被修改，所以需要拷贝i一份
 type ByteView struct {
-	b []byte //包私有
+	b []byte     //包私有
+	e time.Time  //过期时间,零值代表永不过期
+	s time.Time  //软过期时间,过了之后依然返回旧值,同时在后台刷新
+	n *AtomicInt //访问次数,开启refresh-ahead的时候才会统计
+	c Codec      //b的压缩算法,nil代表没有压缩
+	k int        //大于0代表这是分块存储的value的清单,数据在k个chunk中
+	p []ByteView //分块存储的value的所有chunk
+	v uint64     //版本号,由数据的内容计算,0代表未知
 }
 
-//实现Value接口
+//实现Value接口,压缩的数据返回压缩之后的大小,清单只计算key的大小
 func (v ByteView) Len() int {
-	return len(v.b)
+	n := len(v.b)
+	for _, part := range v.p {
+		n += part.Len()
+	}
+	return n
 }
 
 //返回一个数据切片
 func (v ByteView) ByteSlice() []byte {
+	if v.c != nil || v.p != nil {
+		//解压和拼接出来的是新的切片,不需要拷贝
+		d, _ := v.decode()
+		return d.b
+	}
 	return cloneBytes(v.b)
 }
 
 //以String的形式返回
 func (v ByteView) String() string {
+	if v.c != nil || v.p != nil {
+		return string(v.ByteSlice())
+	}
 	return string(v.b)
 }
 
+//返回解压并且拼接所有chunk之后的ByteView
+func (v ByteView) decode() (ByteView, error) {
+	if v.p != nil {
+		b := make([]byte, 0, v.Len())
+		for _, part := range v.p {
+			d, err := part.decode()
+			if err != nil {
+				return ByteView{}, err
+			}
+			b = append(b, d.b...)
+		}
+		v.b, v.p = b, nil
+		return v, nil
+	}
+	if v.c == nil {
+		return v, nil
+	}
+	b, err := v.c.Decompress(v.b)
+	if err != nil {
+		return ByteView{}, err
+	}
+	v.b, v.c = b, nil
+	return v, nil
+}
+
+//返回过期时间,零值代表永不过期
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+//判断在now时刻是否已经过期
+func (v ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && now.After(v.e)
+}
+
+//判断在now时刻是否需要在后台刷新
+func (v ByteView) stale(now time.Time) bool {
+	return !v.s.IsZero() && now.After(v.s)
+}
+
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
 	copy(c, b)
@@ -0,0 +1,59 @@
+package gacache
+
+import (
+	"sync"
+
+	"github.com/willf/bloom"
+)
+
+//Admission 准入策略,决定加载的数据是否存入缓存,size是key和数据压缩之后实际存入缓存的大小
+//从快照恢复,预热迁移和磁盘二级缓存移回的数据不经过准入策略
+type Admission interface {
+	Admit(key string, size int) bool
+}
+
+//AdmissionFunc 方便将函数转换成Admission
+type AdmissionFunc func(key string, size int) bool
+
+func (f AdmissionFunc) Admit(key string, size int) bool {
+	return f(key, size)
+}
+
+//Doorkeeper TinyLFU中的doorkeeper,key第一次加载的时候只记录到bloom filter中,再次加载才存入缓存
+//用来过滤只访问一次的key,避免它们把热点数据挤出缓存,记录了n个key之后清空,让旧的访问记录失效
+type Doorkeeper struct {
+	mu     sync.Mutex
+	filter *bloom.BloomFilter
+	n      uint
+	count  uint
+}
+
+//新建Doorkeeper,n:清空之前最多记录的key的数量 fpRate:bloom filter的误判率
+func NewDoorkeeper(n uint, fpRate float64) *Doorkeeper {
+	return &Doorkeeper{filter: bloom.NewWithEstimates(n, fpRate), n: n}
+}
+
+func (d *Doorkeeper) Admit(key string, size int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.filter.TestAndAddString(key) {
+		return true
+	}
+	if d.count++; d.count >= d.n {
+		d.filter.ClearAll()
+		d.count = 0
+	}
+	return false
+}
+
+var _ Admission = (*Doorkeeper)(nil)
+
+//数据是否可以存入c,超过单条数据的大小限制或者c的容量的数据不存入缓存
+//否则lru会为了放下它淘汰所有的数据,最后连它自己也被淘汰
+//和内存统计一致,按照压缩之后的大小判断
+func (g *Group) fits(e cacheEntry, c *cache) bool {
+	if max := g.opts.maxEntryBytes; max > 0 && e.size > max {
+		return false
+	}
+	return c.fits(e.size, 1+len(e.chunks))
+}
@@ -0,0 +1,94 @@
+package gacache
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaxEntryBytes(t *testing.T) {
+	big := strings.Repeat("x", 100)
+	g := NewRegistry().NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "big" {
+			return []byte(big), nil
+		}
+		return []byte("630"), nil
+	}), WithMaxEntryBytes(64), WithHotCacheRatio(0))
+	g.Get("Tom")
+	//超过限制的数据依然返回给调用者,但是不存入缓存
+	if v, err := g.Get("big"); err != nil || v.String() != big {
+		t.Fatalf("get big fail: %v", err)
+	}
+	if _, ok := g.mainCache.get("big"); ok || g.Stats.Oversized.Get() != 1 {
+		t.Fatalf("big should not be cached")
+	}
+	if _, ok := g.mainCache.get("Tom"); !ok {
+		t.Fatalf("Tom should not be evicted")
+	}
+
+	//超过cache容量的数据同样不存入,也不会淘汰其他数据
+	g = NewRegistry().NewGroup("scores", 64, GetterFunc(func(key string) ([]byte, error) {
+		if key == "big" {
+			return []byte(big), nil
+		}
+		return []byte("630"), nil
+	}), WithHotCacheRatio(0))
+	g.Get("Tom")
+	g.Get("big")
+	if _, ok := g.mainCache.get("Tom"); !ok || g.Stats.Oversized.Get() != 1 {
+		t.Fatalf("values larger than cacheBytes should not be cached")
+	}
+}
+
+//开启压缩的时候按照压缩之后的大小判断,和内存统计一致
+func TestMaxEntryBytesCompressed(t *testing.T) {
+	big := strings.Repeat("x", 1000)
+	var sizes []int
+	g := NewRegistry().NewGroup("scores", 256, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(big), nil
+	}), WithMaxEntryBytes(100), WithHotCacheRatio(0), WithCompression(Gzip, 64), WithAdmission(AdmissionFunc(func(key string, size int) bool {
+		sizes = append(sizes, size)
+		return true
+	})))
+	if v, err := g.Get("big"); err != nil || v.String() != big {
+		t.Fatalf("get big fail: %v", err)
+	}
+	if _, ok := g.mainCache.get("big"); !ok || g.Stats.Oversized.Get() != 0 {
+		t.Fatalf("compressed big should be cached")
+	}
+	if len(sizes) != 1 || int64(sizes[0]) != g.CacheBytes() {
+		t.Fatalf("admission should see the stored size %d, but got %v", g.CacheBytes(), sizes)
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	g := NewRegistry().NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("630"), nil
+	}), WithAdmission(NewDoorkeeper(100, 0.01)))
+	//第一次加载只记录,第二次加载才存入缓存
+	for i := 0; i < 2; i++ {
+		g.Get("Tom")
+		if _, ok := g.mainCache.get("Tom"); ok != (i == 1) {
+			t.Fatalf("Tom should be admitted on the second load")
+		}
+	}
+	if g.Stats.Rejected.Get() != 1 || g.Stats.LocalLoads.Get() != 2 {
+		t.Fatalf("Tom should be rejected once")
+	}
+
+	g = NewRegistry().NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithAdmission(AdmissionFunc(func(key string, size int) bool {
+		return !strings.HasPrefix(key, "tmp:")
+	})))
+	g.Get("tmp:Tom")
+	g.Get("Jack")
+	if _, ok := g.mainCache.get("tmp:Tom"); ok || g.Stats.Rejected.Get() != 1 {
+		t.Fatalf("tmp:Tom should be rejected")
+	}
+	if _, ok := g.mainCache.get("Jack"); !ok {
+		t.Fatalf("Jack should be admitted")
+	}
+	if _, err := NewRegistry().NewGroupWithOptions("scores", 2<<10, g.getter, WithMaxEntryBytes(-1)); err == nil {
+		t.Fatalf("negative max entry bytes should fail")
+	}
+}
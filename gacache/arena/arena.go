@@ -0,0 +1,249 @@
+//arena 把key和value存放在一块预先分配的[]byte(环形缓冲区)中,索引是map[uint64]int64
+//缓冲区和索引都不包含指针,数据再多GC也不需要逐个扫描,思路和bigcache/freecache一样
+//hash冲突的key放在以完整key为索引的overflow中,不会互相覆盖
+//数据从头部追加写入,空间不足的时候从尾部淘汰最旧的数据,被读取的旧数据会重新写到头部,淘汰顺序近似LRU
+//和lru.Cache一样不是并发安全的,由调用者加锁
+package arena
+
+import "encoding/binary"
+
+const (
+	//hash(8) | keyLen(4) | valueLen(4) | flags(1)
+	headerSize = 17
+)
+
+const (
+	flagDeleted = 1 << iota //被删除或者覆盖,等待淘汰的时候回收空间
+	flagPadding             //缓冲区末尾放不下数据时的填充
+)
+
+type Cache struct {
+	buf       []byte
+	head      int64                   //下一条数据写入的位置,单调递增,在buf中的下标是head%len(buf)
+	tail      int64                   //最旧的数据的位置
+	index     map[uint64]int64        //hash(key)到数据位置的映射
+	overflow  map[string]int64        //和index中已有的key冲突的key到数据位置的映射,很少使用
+	hash      func(key string) uint64 //测试中可以替换成容易冲突的hash
+	nbytes    int64                   //有效数据占用的内存,包括头部
+	fifo      bool                    //FIFO模式,Get的时候不把数据移到头部
+	scratch   []byte                  //移动数据时使用的临时缓冲区
+	OnEvicted func(key string, value []byte)
+}
+
+//New maxBytes:缓冲区大小,超过它的数据不会存入 onEvicted:数据被淘汰或者删除的回调,value指向缓冲区,需要保留的时候自己拷贝
+func New(maxBytes int64, onEvicted func(key string, value []byte)) *Cache {
+	return &Cache{
+		buf:       make([]byte, maxBytes),
+		index:     make(map[uint64]int64),
+		overflow:  make(map[string]int64),
+		hash:      hash,
+		OnEvicted: onEvicted,
+	}
+}
+
+//NewFIFO 和New一样,但是按照写入顺序淘汰,访问不会改变淘汰顺序
+func NewFIFO(maxBytes int64, onEvicted func(key string, value []byte)) *Cache {
+	c := New(maxBytes, onEvicted)
+	c.fifo = true
+	return c
+}
+
+//FNV-1a
+func hash(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (c *Cache) at(off int64) int {
+	return int(off % int64(len(c.buf)))
+}
+
+//读取off处的数据,padding和不足一个头部的填充返回的size是填充的大小
+func (c *Cache) entry(off int64) (key, value []byte, size int64, flags byte) {
+	i := c.at(off)
+	if len(c.buf)-i < headerSize {
+		return nil, nil, int64(len(c.buf) - i), flagPadding
+	}
+	keyLen := int(binary.LittleEndian.Uint32(c.buf[i+8:]))
+	valueLen := int(binary.LittleEndian.Uint32(c.buf[i+12:]))
+	flags = c.buf[i+16]
+	i += headerSize
+	key = c.buf[i : i+keyLen]
+	value = c.buf[i+keyLen : i+keyLen+valueLen : i+keyLen+valueLen]
+	return key, value, int64(headerSize + keyLen + valueLen), flags
+}
+
+//查找key,比较完整的key,不一致的时候再到overflow中查找
+func (c *Cache) find(key string) (int64, []byte, bool) {
+	if off, ok := c.index[c.hash(key)]; ok {
+		if k, value, _, _ := c.entry(off); string(k) == key {
+			return off, value, true
+		}
+	}
+	//index中的位置被其他key占用,或者占用它的key已经被删除
+	if len(c.overflow) > 0 {
+		if off, ok := c.overflow[key]; ok {
+			_, value, _, _ := c.entry(off)
+			return off, value, true
+		}
+	}
+	return 0, nil, false
+}
+
+//返回的value指向缓冲区,下一次修改Cache之前有效
+func (c *Cache) Get(key string) (value []byte, ok bool) {
+	off, value, ok := c.find(key)
+	if !ok {
+		return nil, false
+	}
+	//在缓冲区后一半的数据快要被淘汰了,重新写到头部
+	if !c.fifo && c.head-off > int64(len(c.buf))/2 {
+		c.scratch = append(c.scratch[:0], value...)
+		c.Put(key, c.scratch)
+		_, value, ok = c.find(key)
+	}
+	return value, ok
+}
+
+//新增or修改,超过缓冲区大小的数据不会存入,会直接调用OnEvicted
+func (c *Cache) Put(key string, value []byte) {
+	h := c.hash(key)
+	//覆盖的时候不调用OnEvicted
+	if off, _, ok := c.find(key); ok {
+		c.drop(off, false)
+	}
+	size := int64(headerSize + len(key) + len(value))
+	if size > int64(len(c.buf)) {
+		if c.OnEvicted != nil {
+			c.OnEvicted(key, value)
+		}
+		return
+	}
+	off := c.reserve(size)
+	i := c.at(off)
+	binary.LittleEndian.PutUint64(c.buf[i:], h)
+	binary.LittleEndian.PutUint32(c.buf[i+8:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(c.buf[i+12:], uint32(len(value)))
+	c.buf[i+16] = 0
+	i += headerSize
+	i += copy(c.buf[i:], key)
+	copy(c.buf[i:], value)
+	if _, ok := c.index[h]; ok {
+		c.overflow[key] = off
+	} else {
+		c.index[h] = off
+	}
+	c.nbytes += size
+}
+
+//删除指定的key
+func (c *Cache) Remove(key string) {
+	if off, _, ok := c.find(key); ok {
+		c.drop(off, true)
+	}
+}
+
+//将off处的数据标记为删除,evicted代表需要调用OnEvicted
+func (c *Cache) drop(off int64, evicted bool) {
+	key, value, size, _ := c.entry(off)
+	c.buf[c.at(off)+16] |= flagDeleted
+	if h := binary.LittleEndian.Uint64(c.buf[c.at(off):]); c.owns(h, off) {
+		delete(c.index, h)
+	} else {
+		delete(c.overflow, string(key))
+	}
+	c.nbytes -= size
+	if evicted && c.OnEvicted != nil {
+		c.OnEvicted(string(key), value)
+	}
+}
+
+//index中h的位置是否是off
+func (c *Cache) owns(h uint64, off int64) bool {
+	o, ok := c.index[h]
+	return ok && o == off
+}
+
+//在头部预留size大小的空间,不够的时候淘汰最旧的数据,缓冲区末尾放不下的时候从头开始写
+func (c *Cache) reserve(size int64) int64 {
+	n := int64(len(c.buf))
+	for {
+		off := c.head
+		if rest := n - off%n; rest < size {
+			off += rest
+		}
+		if c.tail == c.head {
+			//已经空了,直接从off开始
+			c.tail, c.head = off, off
+		}
+		if off+size-c.tail <= n {
+			c.pad(c.head, off-c.head)
+			c.head = off + size
+			return off
+		}
+		c.RemoveOldest()
+	}
+}
+
+//在缓冲区末尾写入填充,不足一个头部的部分由entry识别
+func (c *Cache) pad(off, size int64) {
+	if size < headerSize {
+		return
+	}
+	i := c.at(off)
+	binary.LittleEndian.PutUint64(c.buf[i:], 0)
+	binary.LittleEndian.PutUint32(c.buf[i+8:], 0)
+	binary.LittleEndian.PutUint32(c.buf[i+12:], uint32(size-headerSize))
+	c.buf[i+16] = flagPadding
+}
+
+//从尾巴淘汰一条数据,跳过已经删除的数据和填充
+func (c *Cache) RemoveOldest() {
+	for c.tail < c.head {
+		_, _, size, flags := c.entry(c.tail)
+		if flags == 0 {
+			c.drop(c.tail, true)
+			c.tail += size
+			return
+		}
+		c.tail += size
+	}
+}
+
+//调整缓冲区大小,重新分配缓冲区并按照从旧到新的顺序写入数据,放不下的旧数据会被淘汰
+func (c *Cache) SetMaxBytes(maxBytes int64) {
+	old := *c
+	c.buf = make([]byte, maxBytes)
+	c.head, c.tail, c.nbytes = 0, 0, 0
+	c.index = make(map[uint64]int64, len(old.index))
+	c.overflow = make(map[string]int64)
+	old.OnEvicted = nil
+	old.Range(func(key string, value []byte) bool {
+		c.Put(key, value)
+		return true
+	})
+}
+
+//从最旧到最新遍历所有数据,fn返回false的时候停止遍历,遍历过程中不能修改Cache
+func (c *Cache) Range(fn func(key string, value []byte) bool) {
+	for off := c.tail; off < c.head; {
+		key, value, size, flags := c.entry(off)
+		if flags == 0 && !fn(string(key), value) {
+			return
+		}
+		off += size
+	}
+}
+
+func (c *Cache) Len() int {
+	return len(c.index) + len(c.overflow)
+}
+
+//有效数据占用的内存,包括每条数据的头部
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
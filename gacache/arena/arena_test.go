@@ -0,0 +1,183 @@
+package arena
+
+import (
+	"gacache/lru"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetPut(t *testing.T) {
+	var evicted []string
+	c := New(100, func(key string, value []byte) {
+		evicted = append(evicted, key+"="+string(value))
+	})
+	c.Put("Tom", []byte("630"))
+	c.Put("Tom", []byte("589"))
+	if v, ok := c.Get("Tom"); !ok || string(v) != "589" || c.Len() != 1 || c.Bytes() != headerSize+6 {
+		t.Fatalf("get Tom fail")
+	}
+	c.Remove("Tom")
+	if _, ok := c.Get("Tom"); ok || c.Len() != 0 || c.Bytes() != 0 {
+		t.Fatalf("Tom should be removed")
+	}
+	//覆盖不回调,删除回调
+	if len(evicted) != 1 || evicted[0] != "Tom=589" {
+		t.Fatalf("onEvicted should be called on remove, got %v", evicted)
+	}
+	//超过缓冲区的数据不存入
+	c.Put("big", make([]byte, 100))
+	if _, ok := c.Get("big"); ok || len(evicted) != 2 {
+		t.Fatalf("big should not be stored")
+	}
+}
+
+func TestCollision(t *testing.T) {
+	var evicted []string
+	c := NewFIFO(200, func(key string, value []byte) {
+		evicted = append(evicted, key)
+	})
+	//所有的key都冲突
+	c.hash = func(string) uint64 { return 1 }
+	c.Put("a", []byte("1"))
+	c.Put("b", []byte("2"))
+	c.Put("c", []byte("3"))
+	c.Put("b", []byte("4"))
+	for k, want := range map[string]string{"a": "1", "b": "4", "c": "3"} {
+		if v, ok := c.Get(k); !ok || string(v) != want {
+			t.Fatalf("get %s = %q, want %q", k, v, want)
+		}
+	}
+	if c.Len() != 3 || len(evicted) != 0 {
+		t.Fatalf("colliding keys should not evict each other, len %d, evicted %v", c.Len(), evicted)
+	}
+	//删除index中的key之后overflow中的key依然可以访问
+	c.Remove("a")
+	c.RemoveOldest()
+	if _, ok := c.Get("b"); !ok || c.Len() != 1 || len(evicted) != 2 || evicted[1] != "c" {
+		t.Fatalf("b should survive, len %d, evicted %v", c.Len(), evicted)
+	}
+	c.Put("d", []byte("5"))
+	if v, ok := c.Get("d"); !ok || string(v) != "5" || c.Len() != 2 {
+		t.Fatalf("get d fail")
+	}
+}
+
+func TestEvict(t *testing.T) {
+	var evicted []string
+	//每条数据 17 + 2 + 1 = 20byte
+	c := NewFIFO(70, func(key string, value []byte) {
+		evicted = append(evicted, key)
+	})
+	for i := 0; i < 5; i++ {
+		c.Put("k"+strconv.Itoa(i), []byte{byte(i)})
+	}
+	//末尾剩下的10byte放不下,从头开始写
+	if c.Len() != 3 || len(evicted) != 2 || evicted[0] != "k0" || evicted[1] != "k1" {
+		t.Fatalf("oldest entries should be evicted, got %v", evicted)
+	}
+	var keys []string
+	c.Range(func(key string, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 3 || keys[0] != "k2" || keys[2] != "k4" {
+		t.Fatalf("range should be from oldest to newest, got %v", keys)
+	}
+
+	//LRU模式下被读取的旧数据移到头部
+	c = New(200, nil)
+	for i := 0; i < 20; i++ {
+		c.Get("k0")
+		c.Put("k"+strconv.Itoa(i), []byte{byte(i)})
+	}
+	if _, ok := c.Get("k0"); !ok {
+		t.Fatalf("k0 should not be evicted")
+	}
+	c.SetMaxBytes(45)
+	if c.Len() != 2 {
+		t.Fatalf("newest 2 entries should be kept, got %d", c.Len())
+	}
+	if _, ok := c.Get("k19"); !ok {
+		t.Fatalf("k19 should be kept")
+	}
+}
+
+//随机写入和删除,和map对比结果,覆盖环形缓冲区的各种边界
+func TestRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	want := map[string]string{}
+	c := New(1000, func(key string, value []byte) {
+		if want[key] != string(value) {
+			t.Fatalf("evicted %s=%s, want %s", key, value, want[key])
+		}
+		delete(want, key)
+	})
+	for i := 0; i < 100000; i++ {
+		key := strconv.Itoa(r.Intn(100))
+		switch r.Intn(3) {
+		case 0:
+			value := string(make([]byte, r.Intn(100)))
+			c.Put(key, []byte(value))
+			want[key] = value
+		case 1:
+			c.Remove(key)
+		default:
+			v, ok := c.Get(key)
+			if w, has := want[key]; ok != has || string(v) != w {
+				t.Fatalf("get %s got %v, want %v", key, ok, has)
+			}
+		}
+		if c.Len() != len(want) || c.head-c.tail > int64(len(c.buf)) {
+			t.Fatalf("inconsistent cache, len %d want %d", c.Len(), len(want))
+		}
+	}
+}
+
+type bytesValue []byte
+
+func (b bytesValue) Len() int {
+	return len(b)
+}
+
+//写入1M条数据之后一次完整GC的耗时,以及STW的时间
+func BenchmarkGC(b *testing.B) {
+	const entries = 1 << 20
+	value := make([]byte, 32)
+	for _, bench := range []struct {
+		name string
+		fill func() interface{}
+	}{
+		{"lru", func() interface{} {
+			c := lru.New(0, nil)
+			for i := 0; i < entries; i++ {
+				c.Put(strconv.Itoa(i), append(bytesValue(nil), value...))
+			}
+			return c
+		}},
+		{"arena", func() interface{} {
+			c := New(entries*64, nil)
+			for i := 0; i < entries; i++ {
+				c.Put(strconv.Itoa(i), value)
+			}
+			return c
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			c := bench.fill()
+			runtime.GC()
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+			b.StopTimer()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "pause-ns/op")
+			runtime.KeepAlive(c)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package gacache
+
+import (
+	"context"
+	pb "gacache/gacachepb"
+	"sync"
+	"time"
+)
+
+//Broker 节点之间的失效通知通道,key所属的节点在数据变化之后发布,所有节点收到之后删除hotCache中的副本
+//HTTPPool通过长轮询实现了它,LocalBroker用于测试和同一个进程中的多个Registry
+type Broker interface {
+	Publish(in *pb.Invalidation)
+	Subscribe(fn func(in *pb.Invalidation)) (cancel func())
+}
+
+//LocalBroker 进程内的Broker,发布的时候同步通知所有订阅者
+type LocalBroker struct {
+	mu   sync.RWMutex
+	subs map[int]func(in *pb.Invalidation)
+	next int
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{subs: make(map[int]func(in *pb.Invalidation))}
+}
+
+func (b *LocalBroker) Publish(in *pb.Invalidation) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.subs {
+		fn(in)
+	}
+}
+
+func (b *LocalBroker) Subscribe(fn func(in *pb.Invalidation)) (cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+//收到失效通知之后删除hotCache中的副本
+func (r *Registry) invalidate(in *pb.Invalidation) {
+	var groups []*Group
+	if in.Group == "" {
+		groups = r.ListGroups()
+	} else if g := r.GetGroup(in.Group); g != nil {
+		groups = []*Group{g}
+	}
+	for _, g := range groups {
+		if in.Key == "" {
+			g.hotCache.clear()
+			g.Stats.Invalidations.Add(1)
+		} else if g.removeFrom(in.Key, &g.hotCache) {
+			g.Stats.Invalidations.Add(1)
+		}
+	}
+}
+
+//通知所有节点key的值已经变化,没有注册Broker的时候直接请求每个节点
+func (g *Group) publish(ctx context.Context, key string) {
+	if g.broker != nil {
+		g.broker.Publish(&pb.Invalidation{Group: g.name, Key: key})
+		return
+	}
+	g.invalidatePeers(ctx, key)
+}
+
+//保留的失效通知数量,轮询落后更多的节点需要删除所有副本
+const invalidationLogSize = 1024
+
+//最近发布的失效通知,供其他节点长轮询
+type invalidationLog struct {
+	mu    sync.Mutex
+	epoch uint64
+	seq   uint64             //最后一条通知的序号
+	items []*pb.Invalidation //最近的通知,最后一条的序号是seq
+	wait  chan struct{}      //有新通知的时候关闭
+}
+
+func newInvalidationLog() *invalidationLog {
+	return &invalidationLog{epoch: uint64(time.Now().UnixNano()), wait: make(chan struct{})}
+}
+
+func (l *invalidationLog) append(in *pb.Invalidation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	if len(l.items) == invalidationLogSize {
+		copy(l.items, l.items[1:])
+		l.items = l.items[:len(l.items)-1]
+	}
+	l.items = append(l.items, in)
+	close(l.wait)
+	l.wait = make(chan struct{})
+}
+
+//返回序号seq之后的通知,没有新通知的时候同时返回等待用的channel
+//seq为0代表刚开始订阅,返回保留的所有通知,多删除几个副本也比漏掉通知好
+func (l *invalidationLog) since(seq, epoch uint64) (*pb.Invalidations, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := &pb.Invalidations{Seq: l.seq, Epoch: l.epoch}
+	switch first := l.seq - uint64(len(l.items)) + 1; {
+	case seq == 0 && len(l.items) > 0:
+		out.Items = append(out.Items, l.items...)
+	case seq == 0:
+		return out, l.wait
+	case epoch != l.epoch || seq > l.seq || seq+1 < first:
+		//节点重启过或者通知已经被覆盖
+		out.Missed = true
+	case seq == l.seq:
+		return out, l.wait
+	default:
+		out.Items = append(out.Items, l.items[seq+1-first:]...)
+	}
+	return out, nil
+}
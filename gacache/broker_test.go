@@ -0,0 +1,84 @@
+package gacache
+
+import (
+	"context"
+	pb "gacache/gacachepb"
+	"testing"
+)
+
+func TestLocalBroker(t *testing.T) {
+	b := NewLocalBroker()
+	value := "630"
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	})
+	owner, other := NewRegistry(), NewRegistry()
+	owner.RegisterBroker(b)
+	g1 := owner.NewGroup("scores", 2<<10, getter)
+	g2 := other.NewGroup("scores", 2<<10, getter)
+	other.RegisterBroker(b)
+	hot := func() bool {
+		_, ok := g2.hotCache.get("Tom")
+		return ok
+	}
+	g2.populateCache("Tom", ByteView{b: []byte(value)}, &g2.hotCache)
+	//Set之后通知所有节点删除副本
+	if err := g1.Set(context.Background(), "Tom", []byte("589")); err != nil {
+		t.Fatal(err)
+	}
+	if hot() || g2.Stats.Invalidations.Get() != 1 {
+		t.Fatalf("hot copy should be invalidated after set")
+	}
+	//重新加载到相同的值不通知,不同的值通知
+	g2.populateCache("Tom", ByteView{b: []byte(value)}, &g2.hotCache)
+	value = "589"
+	g1.getLocally("Tom")
+	if !hot() {
+		t.Fatalf("hot copy should be kept when value not changed")
+	}
+	value = "0"
+	g1.getLocally("Tom")
+	if hot() {
+		t.Fatalf("hot copy should be invalidated after reload")
+	}
+	//group为空的时候删除所有副本
+	g2.populateCache("Tom", ByteView{b: []byte(value)}, &g2.hotCache)
+	b.Publish(&pb.Invalidation{})
+	if hot() {
+		t.Fatalf("all hot copies should be invalidated")
+	}
+}
+
+func TestInvalidationLog(t *testing.T) {
+	l := newInvalidationLog()
+	//刚开始订阅,没有通知的时候等待
+	out, wait := l.since(0, 0)
+	if wait == nil || len(out.Items) != 0 {
+		t.Fatalf("should wait for invalidations")
+	}
+	l.append(&pb.Invalidation{Key: "Tom"})
+	select {
+	case <-wait:
+	default:
+		t.Fatalf("wait should be closed after append")
+	}
+	if out, _ = l.since(0, 0); len(out.Items) != 1 || out.Seq != 1 {
+		t.Fatalf("new subscriber should get retained invalidations")
+	}
+	for i := 0; i < invalidationLogSize+1; i++ {
+		l.append(&pb.Invalidation{Key: "Jack"})
+	}
+	if out, _ = l.since(l.seq-1, l.epoch); len(out.Items) != 1 || out.Missed {
+		t.Fatalf("expect 1 invalidation, but got %d", len(out.Items))
+	}
+	if _, wait = l.since(l.seq, l.epoch); wait == nil {
+		t.Fatalf("should wait for new invalidations")
+	}
+	//通知已经被覆盖或者节点重启过
+	if out, _ = l.since(1, l.epoch); !out.Missed {
+		t.Fatalf("overwritten invalidations should be missed")
+	}
+	if out, _ = l.since(l.seq, l.epoch+1); !out.Missed {
+		t.Fatalf("invalidations should be missed after restart")
+	}
+}
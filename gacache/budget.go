@@ -0,0 +1,225 @@
+package gacache
+
+import (
+	"fmt"
+	"gacache/lru"
+	"log"
+	"sync"
+	"time"
+)
+
+//每次调整的内存占总预算的比例
+const budgetStepRatio = 0.05
+
+//进程级别的内存预算管理,总内存固定,按照各个Group观察到的命中率提升重新分配内存
+//每个Group记录最近因为内存不足被mainCache淘汰的key(ghost),总大小为每次调整的内存
+//之后miss的key如果还在ghost中,说明多分配这部分内存就能命中,ghost命中次数/所有Group的Get次数
+//就是多分配给该Group的内存带来的整体命中率提升,每次Rebalance从提升最低的Group拿出内存给最高的Group
+type Budget struct {
+	mu       sync.Mutex
+	total    int64 //总内存
+	minBytes int64 //每个Group至少分配的内存
+	groups   []*budgetGroup
+}
+
+type budgetGroup struct {
+	g        *Group
+	bytes    int64 //当前分配的内存,0代表还没有分配过
+	lastGets int64 //上一次Rebalance时的Get次数
+	//ghost在事件回调中更新,不使用Budget的锁,Rebalance调整大小的时候会同步触发淘汰事件
+	mu        sync.Mutex
+	ghost     *lru.Cache
+	ghostHits int64 //上一次Rebalance以来ghost命中的次数
+}
+
+//ghost中只记录被淘汰数据的大小
+type ghostValue int
+
+func (v ghostValue) Len() int {
+	return int(v)
+}
+
+//新建内存预算,总内存平均分配给所有的Group,minBytes为每个Group最少分配的内存
+//Group的内存为0代表不限制,所以minBytes必须大于0
+func NewBudget(total, minBytes int64, groups ...*Group) (*Budget, error) {
+	if total <= 0 {
+		return nil, fmt.Errorf("invalid total bytes %d", total)
+	}
+	if minBytes <= 0 || minBytes*int64(len(groups)) > total {
+		return nil, fmt.Errorf("invalid min bytes %d for %d groups", minBytes, len(groups))
+	}
+	b := &Budget{total: total, minBytes: minBytes}
+	for _, g := range groups {
+		b.groups = append(b.groups, b.newBudgetGroup(g))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.split(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//加入新的Group,重新平均分配内存
+func (b *Budget) Add(g *Group) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.minBytes*int64(len(b.groups)+1) > b.total {
+		return fmt.Errorf("budget %d is not enough for %d groups", b.total, len(b.groups)+1)
+	}
+	old := b.groups
+	b.groups = append(b.groups[:len(old):len(old)], b.newBudgetGroup(g))
+	if err := b.split(); err != nil {
+		//恢复原来的分配
+		b.groups = old
+		b.split()
+		return err
+	}
+	return nil
+}
+
+func (b *Budget) newBudgetGroup(g *Group) *budgetGroup {
+	bg := &budgetGroup{g: g, ghost: lru.New(b.step(), nil)}
+	g.Subscribe(bg.observe)
+	return bg
+}
+
+//每次调整的内存
+func (b *Budget) step() int64 {
+	return int64(float64(b.total) * budgetStepRatio)
+}
+
+//被淘汰的key记入ghost,之后加载的时候如果还在ghost中则记一次ghost命中
+func (bg *budgetGroup) observe(e Event) {
+	switch {
+	case e.Type == EventEvict && !e.Hot && e.Reason == EvictCapacity:
+		bg.mu.Lock()
+		bg.ghost.Put(e.Key, ghostValue(e.Value.Len()))
+		bg.mu.Unlock()
+	case e.Type == EventLocalLoad:
+		bg.mu.Lock()
+		if _, ok := bg.ghost.Get(e.Key); ok {
+			bg.ghost.Remove(e.Key)
+			bg.ghostHits++
+		}
+		bg.mu.Unlock()
+	}
+}
+
+//上一次Rebalance以来的Get次数和ghost命中次数
+func (bg *budgetGroup) take() (gets, ghostHits int64) {
+	total := bg.g.Stats.Gets.Get()
+	gets, bg.lastGets = total-bg.lastGets, total
+	bg.mu.Lock()
+	ghostHits, bg.ghostHits = bg.ghostHits, 0
+	bg.mu.Unlock()
+	return gets, ghostHits
+}
+
+//平均分配内存,先缩小再扩大,保证任何时候总内存都不会超出预算
+func (b *Budget) split() error {
+	n := int64(len(b.groups))
+	bytes := make([]int64, n)
+	for i := range b.groups {
+		bytes[i] = b.total / n
+		if int64(i) < b.total%n {
+			bytes[i]++
+		}
+	}
+	for _, shrink := range []bool{true, false} {
+		for i, bg := range b.groups {
+			current := bg.bytes
+			if current == 0 {
+				//还没有分配过,和Group创建时的大小比较,0代表不限制,需要缩小
+				current = bg.g.maxBytes()
+			}
+			if (current == 0 || bytes[i] < current) != shrink {
+				continue
+			}
+			if err := bg.g.SetCacheBytes(bytes[i]); err != nil {
+				return fmt.Errorf("resize group %s: %v", bg.g.name, err)
+			}
+			bg.bytes = bytes[i]
+		}
+	}
+	return nil
+}
+
+//每个Group当前分配的内存
+func (b *Budget) Allocations() map[string]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m := make(map[string]int64, len(b.groups))
+	for _, bg := range b.groups {
+		m[bg.g.name] = bg.bytes
+	}
+	return m
+}
+
+//根据上一次Rebalance以来观察到的命中率提升重新分配内存,调整失败的时候保持原来的分配
+func (b *Budget) Rebalance() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.groups) < 2 {
+		return nil
+	}
+	var total int64
+	hits := make([]int64, len(b.groups))
+	for i, bg := range b.groups {
+		var gets int64
+		gets, hits[i] = bg.take()
+		total += gets
+	}
+	if total == 0 {
+		return nil
+	}
+	var donor, receiver *budgetGroup
+	var minGain, maxGain float64
+	for i, bg := range b.groups {
+		gain := float64(hits[i]) / float64(total)
+		if receiver == nil || gain > maxGain {
+			receiver, maxGain = bg, gain
+		}
+		if donor == nil || gain < minGain {
+			donor, minGain = bg, gain
+		}
+	}
+	if donor == receiver || maxGain <= minGain {
+		return nil
+	}
+	step := b.step()
+	if donor.bytes-step < b.minBytes {
+		step = donor.bytes - b.minBytes
+	}
+	if step <= 0 {
+		return nil
+	}
+	//先缩小再扩大,保证任何时候总内存都不会超出预算
+	if err := donor.g.SetCacheBytes(donor.bytes - step); err != nil {
+		return fmt.Errorf("resize group %s: %v", donor.g.name, err)
+	}
+	if err := receiver.g.SetCacheBytes(receiver.bytes + step); err != nil {
+		donor.g.SetCacheBytes(donor.bytes)
+		return fmt.Errorf("resize group %s: %v", receiver.g.name, err)
+	}
+	donor.bytes -= step
+	receiver.bytes += step
+	log.Printf("[Budget] move %d bytes from %s to %s", step, donor.g.name, receiver.g.name)
+	return nil
+}
+
+//每隔interval调用一次Rebalance,直到stop被关闭
+func (b *Budget) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Rebalance(); err != nil {
+				log.Println("[Budget] Fail to rebalance", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package gacache
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestBudgetRebalance(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	loop := NewGroup("budget-loop", 0, getter, WithHotCacheRatio(0))
+	scan := NewGroup("budget-scan", 0, getter, WithHotCacheRatio(0))
+	defer DeleteGroup("budget-loop")
+	defer DeleteGroup("budget-scan")
+	b, err := NewBudget(1000, 100, loop, scan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := b.Allocations(); a["budget-loop"] != 500 || a["budget-scan"] != 500 {
+		t.Fatalf("budget should be split evenly, but got %v", a)
+	}
+	//loop循环访问66个key(528byte),比分配的内存多一点,每次miss的key都刚被淘汰,多分配内存就能命中
+	//scan每次都访问新的key,miss更多但是多分配内存也不会命中
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 66; i++ {
+			loop.Get(fmt.Sprintf("k%03d", i))
+			scan.Get(fmt.Sprintf("%04d", round*66+i))
+		}
+		if err := b.Rebalance(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := b.Allocations()
+	if a["budget-loop"] <= 528 || a["budget-loop"]+a["budget-scan"] != 1000 {
+		t.Fatalf("budget should move to loop group, but got %v", a)
+	}
+	if loop.maxBytes() != a["budget-loop"] {
+		t.Fatalf("loop group should be resized")
+	}
+	//放下整个工作集之后全部命中,不再调整
+	hits := loop.Stats.MainCacheHits.Get()
+	for i := 0; i < 66; i++ {
+		loop.Get(fmt.Sprintf("k%03d", i))
+	}
+	b.Rebalance()
+	if loop.Stats.MainCacheHits.Get() != hits+66 || b.Allocations()["budget-loop"] != a["budget-loop"] {
+		t.Fatalf("loop group should hit after rebalance, allocations %v", b.Allocations())
+	}
+	if _, err := NewBudget(100, 100, loop, scan); err == nil {
+		t.Fatalf("min bytes exceed total should fail")
+	}
+	if _, err := NewBudget(100, 0, loop, scan); err == nil {
+		t.Fatalf("zero min bytes should fail")
+	}
+}
+
+//还没有分配过的Group和创建时的大小比较,比预算大的先缩小,比预算小的最后扩大
+func TestBudgetInitialSplit(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	small := NewGroup("budget-small", 100, getter, WithHotCacheRatio(0))
+	large := NewGroup("budget-large", 0, getter, WithHotCacheRatio(0))
+	defer DeleteGroup("budget-small")
+	defer DeleteGroup("budget-large")
+	for i := 0; i < 100; i++ {
+		large.Get(fmt.Sprintf("%04d", i))
+	}
+	//large缩小淘汰数据的时候small还没有扩大
+	smallBytes := int64(-1)
+	large.Subscribe(func(e Event) {
+		if smallBytes < 0 {
+			smallBytes = small.maxBytes()
+		}
+	})
+	if _, err := NewBudget(600, 100, small, large); err != nil {
+		t.Fatal(err)
+	}
+	if smallBytes != 100 {
+		t.Fatalf("large group should shrink before small group grows, got %d", smallBytes)
+	}
+	if small.maxBytes() != 300 || large.maxBytes() != 300 {
+		t.Fatalf("expect 300/300 bytes, but got %d/%d", small.maxBytes(), large.maxBytes())
+	}
+}
+
+func TestBudgetSmall(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	a := NewGroup("budget-a", 0, getter)
+	c := NewGroup("budget-b", 0, getter)
+	defer DeleteGroup("budget-a")
+	defer DeleteGroup("budget-b")
+	//每个Group只有4byte,hotCache按比例计算是0,不能变成不限制
+	if _, err := NewBudget(8, 4, a, c); err != nil {
+		t.Fatal(err)
+	}
+	if a.mainCache.cacheBytes != 3 || a.hotCache.cacheBytes != 1 {
+		t.Fatalf("expect 3/1 bytes, but got %d/%d", a.mainCache.cacheBytes, a.hotCache.cacheBytes)
+	}
+	//1byte不够同时分给mainCache和hotCache
+	if _, err := NewBudget(2, 1, a, c); err == nil {
+		t.Fatalf("budget too small for hot cache should fail")
+	}
+}
+
+func TestSetCacheBytes(t *testing.T) {
+	g := NewGroup("resize", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithHotCacheRatio(0))
+	defer DeleteGroup("resize")
+	for i := 0; i < 10; i++ {
+		g.Get(strconv.Itoa(i)) //每个key占2byte
+	}
+	g.SetCacheBytes(10)
+	if n := g.mainCache.lru.Len(); n != 5 {
+		t.Fatalf("shrink to 10 bytes should keep 5 keys, but got %d", n)
+	}
+	//最近访问的数据保留
+	if _, ok := g.mainCache.get("9"); !ok {
+		t.Fatalf("the newest key should be kept")
+	}
+	if err := g.SetCacheBytes(-1); err == nil {
+		t.Fatalf("negative cache bytes should fail")
+	}
+	//0代表不限制,运行时不允许
+	if err := g.SetCacheBytes(0); err == nil {
+		t.Fatalf("zero cache bytes should fail")
+	}
+}
@@ -3,31 +3,171 @@ package gacache
 import (
 	"gacache/lru"
 	"sync"
+	"time"
 )
 
 type cache struct {
 	mu         sync.Mutex
-	lru        *lru.Cache
+	lru        store
 	cacheBytes int64
+	policy     EvictionPolicy   //淘汰策略
+	storage    Storage          //存储引擎
+	overhead   int64            //每条数据额外计算的内存
+	ns         *namespaces      //key所属命名空间的代数,加入实际存储的key中
+	now        func() time.Time //判断过期使用的时钟
+	//数据被移除的回调,在释放锁之后调用
+	onEvicted func(key string, value ByteView, reason EvictReason)
+	evicted   []evictedEntry
+	reason    EvictReason //lru回调时的移除原因,默认是内存不足
+}
+
+type evictedEntry struct {
+	key    string
+	value  ByteView
+	reason EvictReason
 }
 
 func (c *cache) put(key string, value ByteView) {
+	key = c.ns.key(key)
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	if c.lru == nil { //尚未初始化,lazyinit
-		c.lru = lru.New(c.cacheBytes, nil)
+		c.lru = c.newStore()
 	}
 	c.lru.Put(key, value)
+	evicted := c.takeEvicted()
+	c.mu.Unlock()
+	c.deliver(evicted)
+}
+
+//lru的淘汰回调,在持有锁的时候调用,只收集数据
+func (c *cache) collect(key string, v lru.Value) {
+	value := v.(ByteView)
+	reason := c.reason
+	if reason == EvictCapacity && value.expired(c.now()) {
+		reason = EvictExpired
+	}
+	c.evicted = append(c.evicted, evictedEntry{key, value, reason})
+}
+
+func (c *cache) takeEvicted() []evictedEntry {
+	evicted := c.evicted
+	c.evicted = nil
+	return evicted
+}
+
+func (c *cache) deliver(evicted []evictedEntry) {
+	for _, e := range evicted {
+		c.onEvicted(e.key, e.value, e.reason)
+	}
+}
+
+//在持有锁的时候按照指定的原因删除key
+func (c *cache) removeLocked(key string, reason EvictReason) {
+	c.reason = reason
+	c.lru.Remove(key)
+	c.reason = EvictCapacity
 }
 
 func (c *cache) get(key string) (value ByteView, ok bool) {
+	key = c.ns.key(key)
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	if c.lru == nil {
+		c.mu.Unlock()
 		return
 	}
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+	if v, hit := c.lru.Get(key); hit {
+		value, ok = v.(ByteView), true
+		//已经过期的直接删除
+		if value.expired(c.now()) {
+			c.removeLocked(key, EvictExpired)
+			value, ok = ByteView{}, false
+		}
 	}
+	//arena读取旧数据时会重新写到头部,可能淘汰其他数据
+	evicted := c.takeEvicted()
+	c.mu.Unlock()
+	c.deliver(evicted)
 	return
 }
+
+//删除key,返回key是否存在
+func (c *cache) remove(key string) bool {
+	key = c.ns.key(key)
+	c.mu.Lock()
+	if c.lru == nil {
+		c.mu.Unlock()
+		return false
+	}
+	_, ok := c.lru.Get(key)
+	if ok {
+		c.removeLocked(key, EvictDeleted)
+	}
+	evicted := c.takeEvicted()
+	c.mu.Unlock()
+	c.deliver(evicted)
+	return ok
+}
+
+//从最旧到最新遍历没有过期的数据,跳过旧代数的数据,fn返回false的时候停止
+func (c *cache) rangeEntries(fn func(key string, value ByteView) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return
+	}
+	now := c.now()
+	c.lru.Range(func(key string, v lru.Value) bool {
+		view := v.(ByteView)
+		if view.expired(now) {
+			return true
+		}
+		key, gen := splitNamespace(key)
+		if _, current := c.ns.lookup(key); gen != current {
+			return true
+		}
+		return fn(key, view)
+	})
+}
+
+//总大小为size的n条数据能否放入cache,cacheBytes为0代表没有限制
+func (c *cache) fits(size int64, n int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cacheBytes == 0 || size+int64(n)*c.overhead <= c.cacheBytes
+}
+
+//最大内存,0代表没有限制
+func (c *cache) maxBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cacheBytes
+}
+
+//已使用的内存
+func (c *cache) bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.Bytes()
+}
+
+//调整cache的最大内存,已有的数据不会丢弃(除非超出新的大小)
+func (c *cache) setCacheBytes(cacheBytes int64) {
+	c.mu.Lock()
+	c.cacheBytes = cacheBytes
+	if c.lru != nil {
+		c.lru.SetMaxBytes(cacheBytes)
+	}
+	evicted := c.takeEvicted()
+	c.mu.Unlock()
+	c.deliver(evicted)
+}
+
+//清空所有数据,下次put的时候重新初始化
+func (c *cache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru = nil
+}
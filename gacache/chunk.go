@@ -0,0 +1,118 @@
+package gacache
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+//StreamGetter 可选的回调接口,Getter同时实现了它并且开启了分块存储的时候
+//从数据源流式读取并直接按块存入缓存,不需要一次性读入整个value
+type StreamGetter interface {
+	GetStream(key string) (io.ReadCloser, error)
+}
+
+//分块存储的chunk的key: key + '\x00' + 序号,用户的key不能包含'\x00'
+func chunkKey(key string, i int) string {
+	return key + "\x00" + strconv.Itoa(i)
+}
+
+func isChunkKey(key string) bool {
+	return strings.IndexByte(key, 0) >= 0
+}
+
+//将b切分成size大小的块,切片共用b的内存
+func split(b []byte, size int) []ByteView {
+	parts := make([]ByteView, 0, (len(b)+size-1)/size)
+	for len(b) > size {
+		parts = append(parts, ByteView{b: b[:size:size]})
+		b = b[size:]
+	}
+	return append(parts, ByteView{b: b})
+}
+
+//从r中按照size大小分块读取,只有一块的时候返回普通的ByteView
+//只有io.EOF代表读取完成,连接中断等错误(包括io.ErrUnexpectedEOF)直接返回
+func readChunked(r io.Reader, size int) (ByteView, error) {
+	var parts []ByteView
+	for {
+		buf := make([]byte, size)
+		var n int
+		var err error
+		for n < size && err == nil {
+			var m int
+			m, err = r.Read(buf[n:])
+			n += m
+		}
+		if n > 0 {
+			parts = append(parts, ByteView{b: buf[:n:n]})
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ByteView{}, err
+		}
+	}
+	switch len(parts) {
+	case 0:
+		return ByteView{b: []byte{}}, nil
+	case 1:
+		return parts[0], nil
+	}
+	return ByteView{p: parts}, nil
+}
+
+//如果v是分块存储的清单,从c中取出所有的chunk,有chunk已经被淘汰的时候返回false
+func (g *Group) resolve(key string, v ByteView, c *cache) (ByteView, bool) {
+	if v.k == 0 {
+		return v, true
+	}
+	parts := make([]ByteView, v.k)
+	for i := range parts {
+		part, ok := c.get(chunkKey(key, i))
+		if !ok {
+			return ByteView{}, false
+		}
+		parts[i] = part
+	}
+	return ByteView{p: parts, e: v.e, s: v.s, v: v.v}, true
+}
+
+//流式返回key对应的数据,分块存储的value逐块解压和返回,不会拼接成一个完整的切片
+func (g *Group) GetReader(key string) (io.Reader, error) {
+	v, err := g.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	return newChunkReader(v), nil
+}
+
+func newChunkReader(v ByteView) *chunkReader {
+	if v.p == nil {
+		v.p = []ByteView{v}
+	}
+	return &chunkReader{parts: v.p}
+}
+
+type chunkReader struct {
+	parts []ByteView
+	cur   []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 {
+		if len(r.parts) == 0 {
+			return 0, io.EOF
+		}
+		part, err := r.parts[0].decode()
+		if err != nil {
+			return 0, err
+		}
+		r.parts = r.parts[1:]
+		r.cur = part.b
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
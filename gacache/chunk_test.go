@@ -0,0 +1,90 @@
+package gacache
+
+import (
+	pb "gacache/gacachepb"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+)
+
+//只支持流式读取的数据源
+type streamGetter struct {
+	value string
+	loads int32
+}
+
+func (s *streamGetter) Get(key string) ([]byte, error) {
+	panic("GetStream should be used")
+}
+
+func (s *streamGetter) GetStream(key string) (io.ReadCloser, error) {
+	atomic.AddInt32(&s.loads, 1)
+	return ioutil.NopCloser(strings.NewReader(s.value)), nil
+}
+
+func TestChunkedStorage(t *testing.T) {
+	value := strings.Repeat("0123456789", 10)
+	getter := &streamGetter{value: value}
+	g := NewRegistry().NewGroup("scores", 2<<10, getter, WithChunkSize(16))
+	for i := 0; i < 2; i++ {
+		if v, err := g.Get("Tom"); err != nil || v.String() != value {
+			t.Fatalf("get Tom fail: %v", err)
+		}
+	}
+	r, err := g.GetReader("Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(iotest.OneByteReader(r)); err != nil || string(b) != value {
+		t.Fatalf("read Tom fail: %v", err)
+	}
+	//清单加7个chunk
+	if v, _ := g.mainCache.get("Tom"); v.k != 7 || g.mainCache.lru.Len() != 8 {
+		t.Fatalf("Tom should be stored in 7 chunks")
+	}
+	if atomic.LoadInt32(&getter.loads) != 1 {
+		t.Fatalf("Tom should be loaded once")
+	}
+	//chunk被淘汰之后重新加载
+	g.mainCache.remove(chunkKey("Tom", 3))
+	if v, err := g.Get("Tom"); err != nil || v.String() != value || atomic.LoadInt32(&getter.loads) != 2 {
+		t.Fatalf("Tom should be reloaded after a chunk is evicted")
+	}
+	if _, err := g.Get("Tom\x000"); err == nil {
+		t.Fatalf("key contains NUL should fail")
+	}
+	g.Remove("Tom")
+	if g.mainCache.lru.Len() != 0 {
+		t.Fatalf("chunks should be removed with the key")
+	}
+}
+
+func TestStreamPeer(t *testing.T) {
+	r := NewRegistry()
+	value := strings.Repeat("0123456789", 10)
+	r.NewGroup("scores", 2<<10, &streamGetter{value: value}, WithChunkSize(16))
+	p := NewHTTPPool("http://localhost:8001", WithRegistry(r))
+	server := httptest.NewServer(p)
+	defer server.Close()
+	getter := &httpGetter{addr: server.URL, baseURL: server.URL + defaultPath, client: server.Client()}
+	body, err := getter.GetStream(&pb.Request{Group: "scores", Key: "Tom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	v, err := readChunked(body, 16)
+	if err != nil || len(v.p) != 7 || v.String() != value {
+		t.Fatalf("stream from peer fail: %v", err)
+	}
+	if _, err := getter.GetStream(&pb.Request{Group: "none", Key: "Tom"}); Code(err) != CodeBadRequest {
+		t.Fatalf("stream error should be typed, got %v", err)
+	}
+	//连接中断不能当作读取完成
+	if _, err := readChunked(iotest.TimeoutReader(strings.NewReader(value)), 16); err == nil {
+		t.Fatalf("broken stream should fail")
+	}
+}
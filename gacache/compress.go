@@ -0,0 +1,129 @@
+package gacache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+//Codec 压缩算法,Name同时作为节点之间通讯时的Content-Encoding
+type Codec interface {
+	Name() string
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+var (
+	Gzip    Codec = streamCodec{"gzip", newGzipWriter, newGzipReader}
+	Deflate Codec = streamCodec{"deflate", newZlibWriter, zlib.NewReader} //http中的deflate实际是zlib格式
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{Gzip.Name(): Gzip, Deflate.Name(): Deflate}
+)
+
+//注册自定义的压缩算法,注册之后节点之间才能用它传输数据,所有节点都需要注册
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+func codecOf(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+//请求远程节点时的Accept-Encoding
+func acceptEncoding() string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+//判断Accept-Encoding中是否包含name,不处理q值
+func accepts(header, name string) bool {
+	for _, s := range strings.Split(header, ",") {
+		if i := strings.IndexByte(s, ';'); i >= 0 {
+			s = s[:i]
+		}
+		if strings.TrimSpace(s) == name {
+			return true
+		}
+	}
+	return false
+}
+
+//基于标准库流式压缩的Codec
+type streamCodec struct {
+	name      string
+	newWriter func(w io.Writer) io.WriteCloser
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+func (c streamCodec) Name() string {
+	return c.name
+}
+
+func (c streamCodec) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := c.newWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c streamCodec) Decompress(b []byte) ([]byte, error) {
+	r, err := c.newReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", c.name, err)
+	}
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", c.name, err)
+	}
+	return out, nil
+}
+
+func newGzipWriter(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+func newGzipReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func newZlibWriter(w io.Writer) io.WriteCloser {
+	return zlib.NewWriter(w)
+}
+
+//超过阈值并且压缩之后变小的数据才会压缩存储
+func (g *Group) compress(value ByteView) ByteView {
+	codec := g.opts.codec
+	if codec == nil || value.c != nil || len(value.b) < g.opts.compressThreshold {
+		return value
+	}
+	b, err := codec.Compress(value.b)
+	if err != nil || len(b) >= len(value.b) {
+		return value
+	}
+	value.b, value.c = b, codec
+	return value
+}
@@ -0,0 +1,71 @@
+package gacache
+
+import (
+	pb "gacache/gacachepb"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCodec(t *testing.T) {
+	data := []byte(strings.Repeat(`{"name":"Tom","score":630}`, 20))
+	for _, c := range []Codec{Gzip, Deflate} {
+		b, err := c.Compress(data)
+		if err != nil || len(b) >= len(data) {
+			t.Fatalf("%s compress fail: %v", c.Name(), err)
+		}
+		if out, err := c.Decompress(b); err != nil || string(out) != string(data) {
+			t.Fatalf("%s decompress fail: %v", c.Name(), err)
+		}
+		if _, err := c.Decompress(data); err == nil {
+			t.Fatalf("%s decompress invalid data should fail", c.Name())
+		}
+	}
+	if !accepts("deflate, gzip;q=0.5", "gzip") || accepts("gzip2", "gzip") {
+		t.Fatalf("parse accept-encoding fail")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	r := NewRegistry()
+	value := strings.Repeat(`{"name":"Tom","score":630}`, 20)
+	g := r.NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "small" {
+			return []byte("630"), nil
+		}
+		return []byte(value), nil
+	}), WithCompression(Gzip, 64))
+	for i := 0; i < 2; i++ {
+		if v, err := g.Get("Tom"); err != nil || v.String() != value || v.Len() != len(value) {
+			t.Fatalf("get Tom fail: %v", err)
+		}
+	}
+	//按照压缩之后的大小计算内存
+	if v, _ := g.mainCache.get("Tom"); v.c == nil || v.Len() >= len(value) || v.String() != value {
+		t.Fatalf("Tom should be stored compressed")
+	}
+	g.Get("small")
+	if v, _ := g.mainCache.get("small"); v.c != nil {
+		t.Fatalf("values below threshold should not be compressed")
+	}
+
+	//节点之间通过Content-Encoding协商
+	p := NewHTTPPool("http://localhost:8001", WithRegistry(r))
+	server := httptest.NewServer(p)
+	defer server.Close()
+	for _, encoding := range []string{"gzip", "br", ""} {
+		req := httptest.NewRequest(http.MethodGet, defaultPath+"scores/Tom", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); (encoding == "gzip") != (got == "gzip") {
+			t.Fatalf("accept %q but got content encoding %q", encoding, got)
+		}
+	}
+	getter := &httpGetter{addr: server.URL, baseURL: server.URL + defaultPath, client: server.Client()}
+	res := &pb.Response{}
+	if err := getter.Get(&pb.Request{Group: "scores", Key: "Tom"}, res); err != nil || string(res.Value) != value {
+		t.Fatalf("get from peer fail: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package gacache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiskCache(t *testing.T) {
+	if _, err := NewGroupWithOptions("disk", 2<<10, GetterFunc(nil), WithDiskCache("", 1<<20)); err == nil {
+		t.Fatalf("disk cache without dir should fail")
+	}
+	dir, err := ioutil.TempDir("", "gacache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var loads int32
+	//mainCache只能放下两个key,淘汰的数据写入磁盘
+	g := NewGroup("disk", 2*int64(len("key0")+len("value0")), GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("value" + key[3:]), nil
+	}), WithHotCacheRatio(0), WithDiskCache(dir, 1<<20))
+	for i := 0; i < 5; i++ {
+		g.Get(fmt.Sprintf("key%d", i))
+	}
+	if s := g.DiskStats(); s.Keys != 3 {
+		t.Fatalf("evicted keys should be spilled to disk, got %+v", s)
+	}
+	if v, err := g.Get("key0"); err != nil || v.String() != "value0" {
+		t.Fatalf("get key0 fail: %v", err)
+	}
+	if atomic.LoadInt32(&loads) != 5 || g.Stats.DiskHits.Get() != 1 {
+		t.Fatalf("key0 should be loaded from disk")
+	}
+	DeleteGroup("disk")
+	if names, _ := filepath.Glob(filepath.Join(dir, "disk", "*")); len(names) != 0 {
+		t.Fatalf("disk cache should be removed after delete, got %v", names)
+	}
+}
+
+//磁盘中的数据同样按照Group的时钟判断过期
+func TestDiskCacheExpire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gacache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var loads int32
+	clock := &testClock{}
+	g := NewGroup("disk-expire", 2*int64(len("key0")+len("value0")), GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("value" + key[3:]), nil
+	}), WithHotCacheRatio(0), WithTTL(time.Minute), WithDiskCache(dir, 1<<20), WithClock(clock.now))
+	defer DeleteGroup("disk-expire")
+	for i := 0; i < 3; i++ {
+		g.Get(fmt.Sprintf("key%d", i))
+	}
+	if s := g.DiskStats(); s.Keys != 1 {
+		t.Fatalf("key0 should be spilled to disk, got %+v", s)
+	}
+	clock.advance(2 * time.Minute)
+	if v, err := g.Get("key0"); err != nil || v.String() != "value0" {
+		t.Fatalf("get key0 fail: %v", err)
+	}
+	if atomic.LoadInt32(&loads) != 4 || g.Stats.DiskHits.Get() != 0 {
+		t.Fatalf("expired key0 should be loaded from source")
+	}
+}
@@ -0,0 +1,370 @@
+//diskcache 基于磁盘的二级缓存,数据追加写入segment文件,内存中维护key到文件位置的索引
+//被覆盖,删除和过期的数据在compaction的时候清理,超出容量的时候淘汰最旧的segment
+//只是缓存,不保证持久化,Open的时候会清空目录中已有的segment
+package diskcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	//crc32(4) | keyLen(4) | valueLen(4) | expire(8),crc32校验除了自己之外的整条记录
+	headerSize          = 20
+	defaultSegmentBytes = 4 << 20
+	segmentExt          = ".seg"
+)
+
+var (
+	ErrClosed   = errors.New("diskcache: closed")
+	ErrTooLarge = errors.New("diskcache: entry too large")
+)
+
+//统计信息
+type Stats struct {
+	Hits        int64 //命中次数
+	Misses      int64 //未命中次数
+	Puts        int64 //写入次数
+	Evictions   int64 //淘汰segment时丢弃的有效数据条数
+	Compactions int64 //compaction次数
+	Keys        int   //有效数据条数
+	LiveBytes   int64 //有效数据占用的磁盘空间
+	DiskBytes   int64 //所有segment占用的磁盘空间
+}
+
+type segment struct {
+	id   int
+	f    *os.File
+	size int64 //文件大小
+	live int64 //有效数据的大小
+}
+
+//数据在磁盘中的位置
+type location struct {
+	seg    *segment
+	offset int64
+	size   int64
+	expire int64 //过期时间(unix纳秒),0代表不过期
+}
+
+type Cache struct {
+	mu           sync.Mutex
+	dir          string
+	maxBytes     int64      //最大磁盘空间
+	segmentBytes int64      //单个segment的大小,写满之后新建segment
+	segments     []*segment //从旧到新,最后一个是正在写入的segment
+	index        map[string]location
+	diskBytes    int64
+	nextID       int
+	closed       bool
+	stats        Stats
+	now          func() time.Time //判断过期使用的时钟
+}
+
+//在dir中新建磁盘缓存,maxBytes:最大磁盘空间 segmentBytes:单个segment的大小,0代表默认值
+func Open(dir string, maxBytes, segmentBytes int64) (*Cache, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("diskcache: invalid max bytes %d", maxBytes)
+	}
+	if segmentBytes <= 0 {
+		segmentBytes = defaultSegmentBytes
+	}
+	//至少可以放下两个segment,淘汰的时候不会一次清空整个缓存
+	if segmentBytes > maxBytes/2 {
+		segmentBytes = maxBytes / 2
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	old, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range old {
+		if err := os.Remove(name); err != nil {
+			return nil, err
+		}
+	}
+	c := &Cache{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+		index:        make(map[string]location),
+		now:          time.Now,
+	}
+	if err := c.roll(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//设置判断过期使用的时钟,默认time.Now
+func (c *Cache) SetClock(now func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+//写入数据,expire为零值代表不过期,已经过期的数据直接丢弃
+func (c *Cache) Put(key string, value []byte, expire time.Time) error {
+	var e int64
+	if !expire.IsZero() {
+		if !expire.After(c.now()) {
+			return nil
+		}
+		e = expire.UnixNano()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if int64(headerSize+len(key)+len(value)) > c.segmentBytes {
+		return ErrTooLarge
+	}
+	if err := c.write(key, value, e); err != nil {
+		return err
+	}
+	c.stats.Puts++
+	return c.shrink()
+}
+
+//读取数据,返回值和过期时间
+func (c *Cache) Get(key string) (value []byte, expire time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	loc, ok := c.index[key]
+	if c.closed || !ok {
+		c.stats.Misses++
+		return nil, time.Time{}, false
+	}
+	if loc.expire != 0 && c.now().UnixNano() > loc.expire {
+		c.remove(key, loc)
+		c.stats.Misses++
+		return nil, time.Time{}, false
+	}
+	k, value, err := readRecord(loc)
+	if err != nil || k != key {
+		//数据损坏,当作不存在
+		c.remove(key, loc)
+		c.stats.Misses++
+		return nil, time.Time{}, false
+	}
+	c.stats.Hits++
+	if loc.expire != 0 {
+		expire = time.Unix(0, loc.expire)
+	}
+	return value, expire, true
+}
+
+//删除key,磁盘空间在compaction的时候回收
+func (c *Cache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if loc, ok := c.index[key]; ok {
+		c.remove(key, loc)
+	}
+}
+
+//清空所有数据
+func (c *Cache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	for _, seg := range c.segments {
+		c.drop(seg)
+	}
+	c.segments = nil
+	c.index = make(map[string]location)
+	return c.roll()
+}
+
+//关闭并删除所有segment
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	var err error
+	for _, seg := range c.segments {
+		if e := c.drop(seg); e != nil {
+			err = e
+		}
+	}
+	c.segments = nil
+	c.index = nil
+	return err
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Keys = len(c.index)
+	s.DiskBytes = c.diskBytes
+	for _, seg := range c.segments {
+		s.LiveBytes += seg.live
+	}
+	return s
+}
+
+func (c *Cache) active() *segment {
+	return c.segments[len(c.segments)-1]
+}
+
+//新建一个segment用于写入
+func (c *Cache) roll() error {
+	c.nextID++
+	name := filepath.Join(c.dir, fmt.Sprintf("%08d%s", c.nextID, segmentExt))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	c.segments = append(c.segments, &segment{id: c.nextID, f: f})
+	return nil
+}
+
+//追加写入一条记录,覆盖的旧数据变成垃圾
+func (c *Cache) write(key string, value []byte, expire int64) error {
+	if c.active().size >= c.segmentBytes {
+		if err := c.roll(); err != nil {
+			return err
+		}
+	}
+	seg := c.active()
+	buf := make([]byte, headerSize+len(key)+len(value))
+	binary.BigEndian.PutUint32(buf[4:], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(value)))
+	binary.BigEndian.PutUint64(buf[12:], uint64(expire))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	binary.BigEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	if _, err := seg.f.WriteAt(buf, seg.size); err != nil {
+		return err
+	}
+	if old, ok := c.index[key]; ok {
+		old.seg.live -= old.size
+	}
+	size := int64(len(buf))
+	c.index[key] = location{seg: seg, offset: seg.size, size: size, expire: expire}
+	seg.size += size
+	seg.live += size
+	c.diskBytes += size
+	return nil
+}
+
+func (c *Cache) remove(key string, loc location) {
+	loc.seg.live -= loc.size
+	delete(c.index, key)
+}
+
+//超出容量的时候,垃圾多的segment做compaction,否则淘汰最旧的segment
+func (c *Cache) shrink() error {
+	for c.diskBytes > c.maxBytes {
+		if len(c.segments) == 1 {
+			if err := c.roll(); err != nil {
+				return err
+			}
+		}
+		if seg := c.garbageSegment(); seg != nil {
+			if err := c.compact(seg); err != nil {
+				return err
+			}
+			continue
+		}
+		c.evict(c.segments[0])
+	}
+	return nil
+}
+
+//垃圾超过一半并且垃圾最多的segment,正在写入的segment除外
+func (c *Cache) garbageSegment() *segment {
+	var victim *segment
+	for _, seg := range c.segments[:len(c.segments)-1] {
+		dead := seg.size - seg.live
+		if dead*2 >= seg.size && (victim == nil || dead > victim.size-victim.live) {
+			victim = seg
+		}
+	}
+	return victim
+}
+
+//将segment中有效并且没有过期的数据重新写入,然后删除该segment
+func (c *Cache) compact(seg *segment) error {
+	now := c.now().UnixNano()
+	for key, loc := range c.index {
+		if loc.seg != seg {
+			continue
+		}
+		if loc.expire != 0 && now > loc.expire {
+			c.remove(key, loc)
+			continue
+		}
+		k, value, err := readRecord(loc)
+		if err != nil || k != key {
+			c.remove(key, loc)
+			continue
+		}
+		if err := c.write(key, value, loc.expire); err != nil {
+			return err
+		}
+	}
+	c.stats.Compactions++
+	c.removeSegment(seg)
+	return c.drop(seg)
+}
+
+//淘汰segment以及其中的所有数据
+func (c *Cache) evict(seg *segment) {
+	for key, loc := range c.index {
+		if loc.seg == seg {
+			c.remove(key, loc)
+			c.stats.Evictions++
+		}
+	}
+	c.removeSegment(seg)
+	c.drop(seg)
+}
+
+func (c *Cache) removeSegment(seg *segment) {
+	for i, s := range c.segments {
+		if s == seg {
+			c.segments = append(c.segments[:i], c.segments[i+1:]...)
+			return
+		}
+	}
+}
+
+//关闭并删除segment文件
+func (c *Cache) drop(seg *segment) error {
+	c.diskBytes -= seg.size
+	seg.f.Close()
+	return os.Remove(seg.f.Name())
+}
+
+//读取一条记录并校验crc
+func readRecord(loc location) (string, []byte, error) {
+	buf := make([]byte, loc.size)
+	if _, err := loc.seg.f.ReadAt(buf, loc.offset); err != nil {
+		return "", nil, err
+	}
+	if crc32.ChecksumIEEE(buf[4:]) != binary.BigEndian.Uint32(buf) {
+		return "", nil, fmt.Errorf("diskcache: checksum mismatch")
+	}
+	keyLen := int64(binary.BigEndian.Uint32(buf[4:]))
+	valueLen := int64(binary.BigEndian.Uint32(buf[8:]))
+	if headerSize+keyLen+valueLen != loc.size {
+		return "", nil, fmt.Errorf("diskcache: invalid record")
+	}
+	return string(buf[headerSize : headerSize+keyLen]), buf[headerSize+keyLen:], nil
+}
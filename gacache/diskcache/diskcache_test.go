@@ -0,0 +1,116 @@
+package diskcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newCache(t *testing.T, maxBytes, segmentBytes int64) (*Cache, string) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Open(dir, maxBytes, segmentBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, dir
+}
+
+func TestGetPut(t *testing.T) {
+	c, dir := newCache(t, 1<<20, 0)
+	defer os.RemoveAll(dir)
+	defer c.Close()
+	c.Put("Tom", []byte("630"), time.Time{})
+	c.Put("Tom", []byte("631"), time.Time{})
+	if v, _, ok := c.Get("Tom"); !ok || string(v) != "631" {
+		t.Fatalf("get Tom fail: %s", v)
+	}
+	now := time.Now()
+	c.SetClock(func() time.Time {
+		return now
+	})
+	c.Put("Jack", []byte("589"), now.Add(time.Millisecond))
+	now = now.Add(2 * time.Millisecond)
+	if _, _, ok := c.Get("Jack"); ok {
+		t.Fatalf("expired key should miss")
+	}
+	c.Remove("Tom")
+	if _, _, ok := c.Get("Tom"); ok {
+		t.Fatalf("removed key should miss")
+	}
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 2 || s.Keys != 0 || s.LiveBytes != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	//每条记录30字节,每个segment放2条
+	c, dir := newCache(t, 120, 60)
+	defer os.RemoveAll(dir)
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		c.Put(fmt.Sprintf("key%d", i), []byte("value"), time.Time{})
+		if s := c.Stats(); s.DiskBytes > 120 {
+			t.Fatalf("disk bytes %d exceed the budget", s.DiskBytes)
+		}
+	}
+	//最旧的数据被淘汰
+	if _, _, ok := c.Get("key0"); ok {
+		t.Fatalf("key0 should be evicted")
+	}
+	if v, _, ok := c.Get("key9"); !ok || string(v) != "value" {
+		t.Fatalf("key9 should be kept")
+	}
+	if s := c.Stats(); s.Evictions == 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	c, dir := newCache(t, 120, 60)
+	defer os.RemoveAll(dir)
+	defer c.Close()
+	c.Put("key0", []byte("value"), time.Time{})
+	//不断覆盖同一个key,旧的segment全是垃圾,通过compaction回收而不是淘汰key0
+	for i := 0; i < 10; i++ {
+		c.Put("key1", []byte(fmt.Sprintf("val%02d", i)), time.Time{})
+	}
+	if v, _, ok := c.Get("key0"); !ok || string(v) != "value" {
+		t.Fatalf("key0 should survive compaction")
+	}
+	if v, _, ok := c.Get("key1"); !ok || string(v) != "val09" {
+		t.Fatalf("key1 should be the latest value, got %s", v)
+	}
+	if s := c.Stats(); s.Compactions == 0 || s.Evictions != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	c, dir := newCache(t, 1<<20, 0)
+	defer os.RemoveAll(dir)
+	defer c.Close()
+	c.Put("Tom", []byte("630"), time.Time{})
+	name := filepath.Join(dir, "00000001"+segmentExt)
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("x"), headerSize)
+	f.Close()
+	if _, _, ok := c.Get("Tom"); ok {
+		t.Fatalf("corrupt record should miss")
+	}
+	c.Close()
+	if names, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt)); len(names) != 0 {
+		t.Fatalf("segments should be removed after close")
+	}
+	if err := c.Put("Tom", []byte("630"), time.Time{}); err != ErrClosed {
+		t.Fatalf("put after close should fail")
+	}
+}
@@ -0,0 +1,133 @@
+package gacache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "gacache/gacachepb"
+	"net"
+	"net/http"
+)
+
+//错误类型,和protobuf中的Code一一对应,节点之间通过Response传递
+type ErrorCode int32
+
+const (
+	CodeNotFound    = ErrorCode(pb.Code_NOT_FOUND)    //key不存在
+	CodeTimeout     = ErrorCode(pb.Code_TIMEOUT)      //请求超时
+	CodeOverloaded  = ErrorCode(pb.Code_OVERLOADED)   //节点过载
+	CodeBadRequest  = ErrorCode(pb.Code_BAD_REQUEST)  //请求不合法
+	CodeInternal    = ErrorCode(pb.Code_INTERNAL)     //其他错误
+	CodeConflict    = ErrorCode(pb.Code_CONFLICT)     //CompareAndSet的版本号不一致
+	CodeNotModified = ErrorCode(pb.Code_NOT_MODIFIED) //数据和请求方已有的版本一致
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case CodeNotFound:
+		return "not found"
+	case CodeTimeout:
+		return "timeout"
+	case CodeOverloaded:
+		return "overloaded"
+	case CodeBadRequest:
+		return "bad request"
+	case CodeInternal:
+		return "internal error"
+	case CodeConflict:
+		return "conflict"
+	case CodeNotModified:
+		return "not modified"
+	}
+	return fmt.Sprintf("ErrorCode(%d)", int32(c))
+}
+
+//错误码对应的http状态码
+func (c ErrorCode) status() int {
+	switch c {
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeTimeout:
+		return http.StatusGatewayTimeout
+	case CodeOverloaded:
+		return http.StatusServiceUnavailable
+	case CodeBadRequest:
+		return http.StatusBadRequest
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeNotModified:
+		return http.StatusNotModified
+	}
+	return http.StatusInternalServerError
+}
+
+//Error 带错误类型的error,可以用errors.Is(err, ErrNotFound)判断类型
+type Error struct {
+	Code ErrorCode
+	Msg  string
+}
+
+//新建带错误类型的error,比如Getter中可以返回NewError(CodeNotFound, "%s not exist", key)
+func NewError(code ErrorCode, format string, v ...interface{}) *Error {
+	return &Error{Code: code, Msg: fmt.Sprintf(format, v...)}
+}
+
+func (e *Error) Error() string {
+	if e.Msg == "" {
+		return e.Code.String()
+	}
+	return e.Msg
+}
+
+//错误类型相同就认为是同一种错误
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrNotFound    = &Error{Code: CodeNotFound}
+	ErrTimeout     = &Error{Code: CodeTimeout}
+	ErrOverloaded  = &Error{Code: CodeOverloaded}
+	ErrBadRequest  = &Error{Code: CodeBadRequest}
+	ErrInternal    = &Error{Code: CodeInternal}
+	ErrConflict    = &Error{Code: CodeConflict}
+	ErrNotModified = &Error{Code: CodeNotModified}
+)
+
+//获取error的错误类型,没有类型的error当作CodeInternal
+func Code(err error) ErrorCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return CodeTimeout
+	}
+	return CodeInternal
+}
+
+//error对应的http状态码
+func HTTPStatus(err error) int {
+	return Code(err).status()
+}
+
+//将http状态码转换为错误类型,用于远程节点没有返回protobuf的情况
+func codeOfStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+		return CodeTimeout
+	case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+		return CodeOverloaded
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusNotModified:
+		return CodeNotModified
+	}
+	return CodeInternal
+}
@@ -0,0 +1,37 @@
+package gacache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	testCase := []struct {
+		err    error
+		code   ErrorCode
+		status int
+	}{
+		{NewError(CodeNotFound, "%s not exist", "Tom"), CodeNotFound, http.StatusNotFound},
+		{fmt.Errorf("load: %w", ErrOverloaded), CodeOverloaded, http.StatusServiceUnavailable},
+		{context.DeadlineExceeded, CodeTimeout, http.StatusGatewayTimeout},
+		{ErrBadRequest, CodeBadRequest, http.StatusBadRequest},
+		{ErrConflict, CodeConflict, http.StatusConflict},
+		{ErrNotModified, CodeNotModified, http.StatusNotModified},
+		{errors.New("boom"), CodeInternal, http.StatusInternalServerError},
+	}
+	for _, c := range testCase {
+		if Code(c.err) != c.code || HTTPStatus(c.err) != c.status {
+			t.Errorf("%v: expect %v %d, but got %v %d", c.err, c.code, c.status, Code(c.err), HTTPStatus(c.err))
+		}
+	}
+	if err := NewError(CodeNotFound, "Tom not exist"); !errors.Is(err, ErrNotFound) || errors.Is(err, ErrInternal) {
+		t.Fatalf("errors.Is should compare error code")
+	}
+	if _, err := NewGroup("errors", 0, GetterFunc(nil)).Get(""); !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("empty key should be a bad request, but got %v", err)
+	}
+	DeleteGroup("errors")
+}
@@ -0,0 +1,113 @@
+package gacache
+
+import "fmt"
+
+//事件类型
+type EventType int
+
+const (
+	EventEvict      EventType = iota //数据从mainCache或者hotCache中移除
+	EventLocalLoad                   //从Getter加载
+	EventPeerLoad                    //从远程节点加载
+	EventHotPromote                  //远程节点的数据存入hotCache
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventEvict:
+		return "evict"
+	case EventLocalLoad:
+		return "local load"
+	case EventPeerLoad:
+		return "peer load"
+	case EventHotPromote:
+		return "hot promote"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+//数据被移除的原因
+type EvictReason int
+
+const (
+	EvictCapacity EvictReason = iota //内存不足
+	EvictExpired                     //过期
+	EvictDeleted                     //调用Remove删除
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictDeleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("EvictReason(%d)", int(r))
+}
+
+//Group中发生的事件
+type Event struct {
+	Type   EventType
+	Key    string
+	Value  ByteView
+	Hot    bool        //EventEvict: 数据是否在hotCache中
+	Reason EvictReason //EventEvict: 移除的原因
+	Peer   string      //EventPeerLoad: 远程节点的名字
+}
+
+type subscriber struct {
+	fn func(Event)
+}
+
+//订阅Group中的事件,返回取消订阅的函数
+//回调在触发事件的goroutine中同步调用,不会持有cache的锁,但是会阻塞当前的请求,耗时的操作需要自己异步处理
+func (g *Group) Subscribe(fn func(Event)) (cancel func()) {
+	s := &subscriber{fn: fn}
+	g.subsMu.Lock()
+	g.subs = append(g.subs, s)
+	g.subsMu.Unlock()
+	return func() {
+		g.subsMu.Lock()
+		defer g.subsMu.Unlock()
+		for i, sub := range g.subs {
+			if sub == s {
+				//复制一份,emit中可能正在遍历旧的切片
+				g.subs = append(g.subs[:i:i], g.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (g *Group) emit(e Event) {
+	g.subsMu.RLock()
+	subs := g.subs
+	g.subsMu.RUnlock()
+	for _, s := range subs {
+		s.fn(e)
+	}
+}
+
+//mainCache和hotCache的移除回调,内存不足被淘汰的mainCache数据写入磁盘二级缓存
+//分块存储的chunk不会写入磁盘,也不会通知订阅者
+func (g *Group) evicted(key string, value ByteView, reason EvictReason, hot bool) {
+	if g.opts.chunkSize > 0 && isChunkKey(key) {
+		return
+	}
+	//磁盘中同样使用带代数的key
+	if g.disk != nil && !hot && reason == EvictCapacity && value.k == 0 {
+		g.spill(key, value)
+	}
+	key, _ = splitNamespace(key)
+	g.emit(Event{Type: EventEvict, Key: key, Value: value, Hot: hot, Reason: reason})
+}
+
+//返回远程节点的名字,HTTPPool的PeerGetter返回节点地址
+func PeerName(peer PeerGetter) string {
+	if s, ok := peer.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%p", peer)
+}
@@ -0,0 +1,48 @@
+package gacache
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGroup("scores", 2*int64(len("key0")+len("key0")), GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithHotCacheRatio(0), WithTTL(50*time.Millisecond))
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	cancel := g.Subscribe(func(e Event) {
+		//回调的时候不持有cache的锁
+		g.mainCache.mu.Lock()
+		g.mainCache.mu.Unlock()
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Type == EventEvict {
+			events = append(events, e.Key+" "+e.Reason.String())
+		} else {
+			events = append(events, e.Key+" "+e.Type.String())
+		}
+	})
+	g.Get("key0")
+	g.Get("key1")
+	g.Get("key2") //key0被淘汰
+	g.Remove("key1")
+	time.Sleep(60 * time.Millisecond)
+	g.Get("key2") //key2过期
+	cancel()
+	g.Get("key3")
+	expect := []string{
+		"key0 local load", "key1 local load", "key0 capacity", "key2 local load",
+		"key1 deleted", "key2 expired", "key2 local load",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(events, expect) {
+		t.Fatalf("expect events %v, but got %v", expect, events)
+	}
+}
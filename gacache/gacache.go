@@ -1,7 +1,10 @@
 package gacache
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"gacache/diskcache"
 	pb "gacache/gacachepb"
 	"gacache/singleflight"
 	"log"
@@ -33,11 +36,59 @@ type Group struct {
 	getter    Getter
 	mainCache cache
 	hotCache  cache
+	ns        namespaces       //命名空间的代数,mainCache和hotCache共用
+	disk      *diskcache.Cache //磁盘二级缓存,没有开启的时候为nil
 	peers     PeerPicker
+	broker    Broker //失效通知通道,没有注册的时候为nil
 	//singleflight并发请求控制
 	loader *singleflight.Group
 	//KeyStats映射
-	keys map[string]*KeyStats
+	keys   map[string]*KeyStats
+	keysMu sync.Mutex
+	//正在后台刷新的key
+	refreshing map[string]bool
+	refreshMu  sync.Mutex
+	//refresh-ahead的任务队列
+	refreshQueue chan string
+	//关闭之后停止所有后台任务
+	stop      chan struct{}
+	closeOnce sync.Once
+	//write-behind队列,没有开启的时候为nil
+	writer *writeBehind
+	//按照key分段的写锁,保证同一个key的CompareAndSet和Set依次执行
+	writeMu [writeLockStripes]sync.Mutex
+	//每个分段的写入次数,由writeMu保护
+	writeSeq [writeLockStripes]uint64
+	//事件订阅者
+	subs   []*subscriber
+	subsMu sync.RWMutex
+	//可选配置
+	opts groupOptions
+	//统计信息
+	Stats Stats
+}
+
+//Group的统计信息
+type Stats struct {
+	Gets            AtomicInt //Get请求次数
+	MainCacheHits   AtomicInt //mainCache命中次数
+	HotCacheHits    AtomicInt //hotCache命中次数
+	Loads           AtomicInt //cache miss之后的加载次数
+	PeerLoads       AtomicInt //从远程节点加载成功的次数
+	PeerErrors      AtomicInt //从远程节点加载失败的次数
+	PeerNotModified AtomicInt //远程节点的数据没有变化,继续使用hotCache中副本的次数
+	Invalidations   AtomicInt //收到失效通知之后删除hotCache中副本的次数
+	LocalLoads      AtomicInt //从数据源加载成功的次数
+	LocalLoadErrs   AtomicInt //从数据源加载失败的次数
+	StaleHits       AtomicInt //返回旧值并在后台刷新的次数
+	RefreshAheads   AtomicInt //提前刷新成功的次数
+	DiskHits        AtomicInt //磁盘二级缓存命中次数
+	Oversized       AtomicInt //超过大小限制没有存入缓存的次数
+	Rejected        AtomicInt //被准入策略拒绝的次数
+	Sets            AtomicInt //Set请求次数
+	Conflicts       AtomicInt //CompareAndSet版本号不一致的次数
+	WriteErrors     AtomicInt //写入数据源失败的次数
+	WriteDrops      AtomicInt //write-behind重试之后依然失败,丢弃的数据条数
 }
 
 //封装一个原子类
@@ -58,71 +109,129 @@ type KeyStats struct {
 	remoteCnt    AtomicInt //利用atomic包封装的原子类
 }
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-)
+//默认的Registry,包级别的NewGroup/GetGroup等方法都作用于它
+var DefaultRegistry = NewRegistry()
 
-//新建Group
-func NewGroup(name string, cacheByte int64, getter Getter) *Group {
-	if getter == nil {
-		panic("nil Getter")
-	}
-	mu.Lock()
-	defer mu.Unlock()
-	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{cacheBytes: cacheByte * 7 / 8},
-		hotCache:  cache{cacheBytes: cacheByte / 8},
-		loader:    &singleflight.Group{},
-		keys:      map[string]*KeyStats{},
-	}
-	groups[name] = g
-	return g
+//在DefaultRegistry中注册Group创建之后的回调
+func RegisterNewGroupHook(fn func(*Group)) {
+	DefaultRegistry.RegisterNewGroupHook(fn)
+}
+
+//在DefaultRegistry中注册Broker
+func RegisterBroker(b Broker) {
+	DefaultRegistry.RegisterBroker(b)
+}
+
+//在DefaultRegistry中新建Group,配置不合法的时候会panic
+func NewGroup(name string, cacheByte int64, getter Getter, opts ...GroupOption) *Group {
+	return DefaultRegistry.NewGroup(name, cacheByte, getter, opts...)
 }
 
-//获取Group
+//在DefaultRegistry中新建Group,配置不合法的时候返回error
+func NewGroupWithOptions(name string, cacheByte int64, getter Getter, opts ...GroupOption) (*Group, error) {
+	return DefaultRegistry.NewGroupWithOptions(name, cacheByte, getter, opts...)
+}
+
+//从DefaultRegistry中获取Group
 func GetGroup(name string) *Group {
-	mu.RLock() //只读操作，用读锁就ok了
-	defer mu.RUnlock()
-	g := groups[name]
-	return g
+	return DefaultRegistry.GetGroup(name)
+}
+
+//从DefaultRegistry中删除Group
+func DeleteGroup(name string) {
+	DefaultRegistry.DeleteGroup(name)
+}
+
+//按照名字排序返回DefaultRegistry中所有的Group
+func ListGroups() []*Group {
+	return DefaultRegistry.ListGroups()
+}
+
+func (g *Group) Name() string {
+	return g.name
 }
 
 func (g *Group) Get(key string) (ByteView, error) {
-	if key == "" {
-		return ByteView{}, fmt.Errorf("key nil")
+	v, err := g.lookup(key)
+	if err != nil {
+		return ByteView{}, err
 	}
+	return v.decode()
+}
+
+//返回缓存中存储的数据(可能是压缩或者分块的),cache miss的时候加载
+func (g *Group) lookup(key string) (ByteView, error) {
+	if err := g.checkKey(key); err != nil {
+		return ByteView{}, err
+	}
+	g.Stats.Gets.Add(1)
 	if v, ok := g.mainCache.get(key); ok {
-		log.Printf("[GaCache (mainCache)] hit")
-		return v, nil
+		if value, ok := g.resolve(key, v, &g.mainCache); ok {
+			log.Printf("[GaCache (mainCache)] hit")
+			g.Stats.MainCacheHits.Add(1)
+			g.revalidate(key, v, false)
+			g.maybeRefreshAhead(key, v)
+			return value, nil
+		}
 	}
 	//add: hotCache
 	if v, ok := g.hotCache.get(key); ok {
-		log.Printf("[GaCache (hotCache)] hit")
-		return v, nil
+		if value, ok := g.resolve(key, v, &g.hotCache); ok {
+			log.Printf("[GaCache (hotCache)] hit")
+			g.Stats.HotCacheHits.Add(1)
+			g.revalidate(key, v, true)
+			return value, nil
+		}
 	}
 	//当前节点没有数据,去其他地方加载
 	return g.load(key)
 }
 
+//检查key是否合法,开启分块存储的时候key不能包含chunk的分隔符
+func (g *Group) checkKey(key string) error {
+	if key == "" {
+		return NewError(CodeBadRequest, "key nil")
+	}
+	if g.opts.chunkSize > 0 && isChunkKey(key) {
+		return NewError(CodeBadRequest, "key contains NUL")
+	}
+	return nil
+}
+
 func (g *Group) load(key string) (value ByteView, err error) {
 	//放大缓存击穿效果
 	//time.Sleep(100 * time.Millisecond)
+	g.Stats.Loads.Add(1)
 	//通过singleflight去加载
 	view, err := g.loader.Do(key, func() (interface{}, error) {
+		if value, ok := g.getFromDisk(key); ok {
+			g.Stats.DiskHits.Add(1)
+			return value, nil
+		}
 		if g.peers != nil {
 			//根据一致性Hash选择节点Peer
 			if peer, ok := g.peers.PickPeer(key); ok {
 				//从上面的Peer中获取数据
 				if value, err = g.getFromPeer(peer, key); err == nil {
+					g.Stats.PeerLoads.Add(1)
+					g.emit(Event{Type: EventPeerLoad, Key: key, Value: value, Peer: PeerName(peer)})
 					return value, nil
 				}
+				//远程节点是key的权威来源,比如它返回key不存在就没有必要再去数据源查询了
+				if !g.opts.peerFallback(err) {
+					return nil, err
+				}
+				g.Stats.PeerErrors.Add(1)
 				log.Println("[Gacache] Fail to get from remote peer!!!", err)
 			}
 		}
-		return g.getLocally(key)
+		value, err := g.getLocally(key)
+		if err != nil {
+			g.Stats.LocalLoadErrs.Add(1)
+			return nil, err
+		}
+		g.Stats.LocalLoads.Add(1)
+		return value, nil
 	})
 	if err == nil {
 		return view.(ByteView), nil
@@ -130,60 +239,344 @@ func (g *Group) load(key string) (value ByteView, err error) {
 	return
 }
 
+//数据软过期之后在后台刷新,同一个key同时只会有一个刷新,刷新同样经过singleflight
+//hot代表旧值在hotCache中,从远程节点加载之后需要更新hotCache
+func (g *Group) revalidate(key string, v ByteView, hot bool) {
+	if !v.stale(g.opts.now()) {
+		return
+	}
+	g.Stats.StaleHits.Add(1)
+	g.refreshMu.Lock()
+	if g.refreshing[key] {
+		g.refreshMu.Unlock()
+		return
+	}
+	g.refreshing[key] = true
+	g.refreshMu.Unlock()
+	go func() {
+		defer func() {
+			g.refreshMu.Lock()
+			delete(g.refreshing, key)
+			g.refreshMu.Unlock()
+		}()
+		value, err := g.load(key)
+		if err != nil {
+			log.Println("[Gacache] Fail to revalidate", key, err)
+			return
+		}
+		if hot {
+			g.populateCache(key, value, &g.hotCache)
+		}
+	}()
+}
+
 //从远程节点获取数据
 func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
-	//构建proto的message
-	req := &pb.Request{
-		Group: g.name,
-		Key:   key,
-	}
-	res := &pb.Response{}
-	err := peer.Get(req, res)
+	value, err := g.fetchFromPeer(peer, key)
 
 	fmt.Println("getFromPeer", key)
 	if err != nil {
 		return ByteView{}, err
 	}
 	//远程获取cnt++
-	if stat, ok := g.keys[key]; ok {
+	g.keysMu.Lock()
+	stat, ok := g.keys[key]
+	if !ok {
+		//第一次获取
+		g.keys[key] = &KeyStats{
+			firstGetTime: g.opts.now(),
+			remoteCnt:    1,
+		}
+	}
+	g.keysMu.Unlock()
+	if ok {
 		stat.remoteCnt.Add(1)
 		//计算QPS
-		interval := float64(time.Now().Unix()-stat.firstGetTime.Unix()) / 60
+		interval := float64(g.opts.now().Unix()-stat.firstGetTime.Unix()) / 60
 		qps := stat.remoteCnt.Get() / int64(math.Max(1, math.Round(interval)))
-		if qps >= maxMinuteRemoteQPS {
+		if qps >= maxMinuteRemoteQPS && g.opts.hotCacheRatio > 0 {
 			//存入hotCache
-			g.populateCache(key, ByteView{b: res.Value}, &g.hotCache)
+			g.populateCache(key, value, &g.hotCache)
+			g.emit(Event{Type: EventHotPromote, Key: key, Value: value})
 			//删除映射关系,节省内存
-			mu.Lock()
+			g.keysMu.Lock()
 			delete(g.keys, key)
-			mu.Unlock()
+			g.keysMu.Unlock()
 		}
-	} else {
-		//第一次获取
-		g.keys[key] = &KeyStats{
-			firstGetTime: time.Now(),
-			remoteCnt:    1,
+	}
+	return value, nil
+}
+
+//请求远程节点,数据没有变化的时候返回hotCache中的副本
+func (g *Group) fetchFromPeer(peer PeerGetter, key string) (ByteView, error) {
+	//构建proto的message
+	req := &pb.Request{
+		Group: g.name,
+		Key:   key,
+	}
+	//带上命名空间的代数,远程节点没有收到BumpNamespace的通知的时候跟着更新
+	req.Namespace, req.Generation = g.ns.lookup(key)
+	//hotCache中有副本(比如软过期之后刷新)的时候带上版本号,远程节点的数据没有变化就不用重新传输
+	known, ok := g.hotCache.get(key)
+	if ok {
+		if known, ok = g.resolve(key, known, &g.hotCache); ok {
+			req.Version = known.v
 		}
 	}
-	return ByteView{b: res.Value}, nil
+	value, err := g.requestPeer(peer, req)
+	if errors.Is(err, ErrNotModified) && req.Version != 0 {
+		g.Stats.PeerNotModified.Add(1)
+		//重新计算过期时间
+		known.e, known.s = time.Time{}, time.Time{}
+		return known, nil
+	}
+	return value, err
+}
+
+//开启分块存储并且远程节点支持的时候流式读取
+func (g *Group) requestPeer(peer PeerGetter, req *pb.Request) (ByteView, error) {
+	if s, ok := peer.(PeerStreamer); ok && g.opts.chunkSize > 0 {
+		body, err := s.GetStream(req)
+		if err != nil {
+			return ByteView{}, err
+		}
+		defer body.Close()
+		value, err := readChunked(body, g.opts.chunkSize)
+		if b, ok := body.(versioned); ok {
+			value.v = b.Version()
+		}
+		return value, err
+	}
+	res := &pb.Response{}
+	if err := peer.Get(req, res); err != nil {
+		return ByteView{}, err
+	}
+	return ByteView{b: res.Value, v: res.Version}, nil
+}
+
+//从磁盘二级缓存获取数据,命中之后移回mainCache
+func (g *Group) getFromDisk(key string) (ByteView, bool) {
+	if g.disk == nil {
+		return ByteView{}, false
+	}
+	b, expire, ok := g.disk.Get(g.ns.key(key))
+	if !ok || len(b) == 0 {
+		return ByteView{}, false
+	}
+	g.disk.Remove(g.ns.key(key))
+	//第一个字节标记数据是否用Group的压缩算法压缩过,之后的8个字节是版本号
+	if len(b) < 9 {
+		return ByteView{}, false
+	}
+	stored := ByteView{b: b[9:], e: expire, v: binary.LittleEndian.Uint64(b[1:])}
+	if b[0] == 1 {
+		stored.c = g.opts.codec
+	}
+	value, err := stored.decode()
+	if err != nil {
+		return ByteView{}, false
+	}
+	g.putCache(key, stored, &g.mainCache)
+	return ByteView{b: value.b, v: value.v}, true
+}
+
+//mainCache淘汰的数据写入磁盘二级缓存,压缩过的数据直接写入
+func (g *Group) spill(key string, value ByteView) {
+	b := make([]byte, len(value.b)+9)
+	if value.c != nil {
+		b[0] = 1
+	}
+	binary.LittleEndian.PutUint64(b[1:], value.v)
+	copy(b[9:], value.b)
+	if err := g.disk.Put(key, b, value.e); err != nil && err != diskcache.ErrTooLarge {
+		log.Println("[Gacache] Fail to spill to disk", key, err)
+	}
+}
+
+//磁盘二级缓存的统计信息,没有开启的时候返回零值
+func (g *Group) DiskStats() diskcache.Stats {
+	if g.disk == nil {
+		return diskcache.Stats{}
+	}
+	return g.disk.Stats()
 }
 
 //从数据源获取数据
 func (g *Group) getLocally(key string) (ByteView, error) {
+	seq := g.writes(key)
+	value, err := g.getFromSource(key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	var changed bool
+	value.v, changed = g.reloadVersion(key, value)
+	g.populateLoaded(key, value, seq)
+	g.emit(Event{Type: EventLocalLoad, Key: key, Value: value})
+	//重新加载到了新的值,通知其他节点删除旧的副本
+	if changed && g.broker != nil {
+		g.broker.Publish(&pb.Invalidation{Group: g.name, Key: key})
+	}
+	return value, nil
+}
+
+//调用回调函数从数据源取数据,开启分块存储并且Getter支持的时候流式读取
+func (g *Group) getFromSource(key string) (ByteView, error) {
+	if s, ok := g.getter.(StreamGetter); ok && g.opts.chunkSize > 0 {
+		body, err := s.GetStream(key)
+		if err != nil {
+			return ByteView{}, err
+		}
+		defer body.Close()
+		return readChunked(body, g.opts.chunkSize)
+	}
 	bytes, err := g.getter.Get(key) //回调函数，从数据源取数据
 	if err != nil {
 		return ByteView{}, err
 	}
 	//将数据源的数据拷贝一份放入cache中，防止其他外部程序占有该数据并修改
-	value := ByteView{b: cloneBytes(bytes)}
-	g.populateCache(key, value, &g.mainCache)
-	return value, nil
+	return ByteView{b: cloneBytes(bytes)}, nil
 }
 
 //将从数据源获取的数据加入cache
 //update: hotCache
 func (g *Group) populateCache(key string, value ByteView, c *cache) {
-	c.put(key, value)
+	//按照压缩之后实际存入的大小判断是否准入
+	e := g.newEntry(key, value, c)
+	if a := g.opts.admission; a != nil && !a.Admit(key, int(e.size)) {
+		g.Stats.Rejected.Add(1)
+		return
+	}
+	g.store(key, e, c)
+}
+
+//不经过准入策略直接存入cache,只检查大小限制,返回是否存入
+func (g *Group) putCache(key string, value ByteView, c *cache) bool {
+	return g.store(key, g.newEntry(key, value, c), c)
+}
+
+//实际存入cache的数据,分块存储的时候value是清单
+type cacheEntry struct {
+	value  ByteView
+	chunks []ByteView //压缩之后的chunk
+	size   int64      //key,value和所有chunk压缩之后的大小,不包括每条数据额外计算的内存
+}
+
+//计算版本号和过期时间,然后按照配置分块和压缩
+func (g *Group) newEntry(key string, value ByteView, c *cache) cacheEntry {
+	if value.v == 0 {
+		value.v = versionOf(value)
+	}
+	now := g.opts.now()
+	//value自带的过期时间(比如从其他节点迁移过来的数据)比ttl早的时候保留
+	if e := now.Add(g.opts.ttl); g.opts.ttl > 0 && (value.e.IsZero() || e.Before(value.e)) {
+		value.e = e
+	}
+	if g.opts.softTTL > 0 {
+		value.s = now.Add(g.opts.softTTL)
+	}
+	//超过chunkSize的value分块存储
+	if size := g.opts.chunkSize; size > 0 && value.p == nil && value.c == nil && len(value.b) > size {
+		value.p = split(value.b, size)
+	}
+	var e cacheEntry
+	if value.p != nil {
+		for i, part := range value.p {
+			part.e = value.e
+			part = g.compress(part)
+			e.chunks = append(e.chunks, part)
+			e.size += int64(len(chunkKey(key, i)) + part.Len())
+		}
+		value = ByteView{e: value.e, s: value.s, k: len(value.p), v: value.v}
+	}
+	if g.refreshQueue != nil && c == &g.mainCache {
+		value.n = new(AtomicInt)
+	}
+	e.value = g.compress(value)
+	e.size += int64(len(key) + e.value.Len())
+	return e
+}
+
+//检查大小限制之后存入cache,先写入chunk再写入清单
+func (g *Group) store(key string, e cacheEntry, c *cache) bool {
+	if !g.fits(e, c) {
+		g.Stats.Oversized.Add(1)
+		return false
+	}
+	for i, part := range e.chunks {
+		c.put(chunkKey(key, i), part)
+	}
+	c.put(key, e.value)
+	return true
+}
+
+//从当前节点的mainCache,hotCache和磁盘二级缓存中删除key,不会通知其他节点
+//返回key是否在内存中
+func (g *Group) Remove(key string) bool {
+	if g.disk != nil {
+		g.disk.Remove(g.ns.key(key))
+	}
+	main := g.removeFrom(key, &g.mainCache)
+	hot := g.removeFrom(key, &g.hotCache)
+	return main || hot
+}
+
+//删除key以及分块存储的chunk
+func (g *Group) removeFrom(key string, c *cache) bool {
+	if v, ok := c.get(key); ok {
+		for i := 0; i < v.k; i++ {
+			c.remove(chunkKey(key, i))
+		}
+	}
+	return c.remove(key)
+}
+
+//清空Group中缓存的所有数据,不会触发EventEvict
+func (g *Group) Clear() {
+	g.mainCache.clear()
+	g.hotCache.clear()
+	if g.disk != nil {
+		g.disk.Clear()
+	}
+	g.keysMu.Lock()
+	g.keys = map[string]*KeyStats{}
+	g.keysMu.Unlock()
+}
+
+//运行时调整Group的内存大小,按照hotCache的比例重新分配mainCache和hotCache
+//缩小的时候按照淘汰策略逐出数据,变大则立即生效
+//cacheBytes必须大于0,运行时不能切换成不限制内存
+func (g *Group) SetCacheBytes(cacheBytes int64) error {
+	if cacheBytes <= 0 {
+		return fmt.Errorf("invalid cache bytes %d", cacheBytes)
+	}
+	mainBytes, hotBytes, err := splitCacheBytes(cacheBytes, g.opts.hotCacheRatio)
+	if err != nil {
+		return err
+	}
+	g.mainCache.setCacheBytes(mainBytes)
+	g.hotCache.setCacheBytes(hotBytes)
+	return nil
+}
+
+//mainCache和hotCache的最大内存,0代表不限制
+func (g *Group) maxBytes() int64 {
+	return g.mainCache.maxBytes() + g.hotCache.maxBytes()
+}
+
+//按照hotCache的比例把内存分给mainCache和hotCache,cacheBytes为0代表两者都不限制
+//因为0代表不限制,启用hotCache的时候两者都至少分配1byte
+func splitCacheBytes(cacheBytes int64, ratio float64) (mainBytes, hotBytes int64, err error) {
+	if cacheBytes == 0 {
+		return 0, 0, nil
+	}
+	hotBytes = int64(float64(cacheBytes) * ratio)
+	if ratio > 0 && hotBytes == 0 {
+		hotBytes = 1
+	}
+	if mainBytes = cacheBytes - hotBytes; mainBytes <= 0 {
+		return 0, 0, fmt.Errorf("cache bytes %d is too small for hot cache ratio %v", cacheBytes, ratio)
+	}
+	return mainBytes, hotBytes, nil
 }
 
 func (g *Group) RegisterPeers(peers PeerPicker) {
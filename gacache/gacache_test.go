@@ -3,8 +3,12 @@ package gacache
 import (
 	"fmt"
 	"log"
+	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var db = map[string]string{
@@ -13,6 +17,19 @@ var db = map[string]string{
 	"Sam":     "Nice",
 }
 
+//测试中使用的时钟,advance让时间前进,不需要真的等待
+type testClock struct {
+	offset int64
+}
+
+func (c *testClock) now() time.Time {
+	return time.Now().Add(time.Duration(atomic.LoadInt64(&c.offset)))
+}
+
+func (c *testClock) advance(d time.Duration) {
+	atomic.AddInt64(&c.offset, int64(d))
+}
+
 func TestGetter(t *testing.T) {
 	var f Getter = GetterFunc(func(key string) ([]byte, error) {
 		return []byte(key), nil
@@ -37,6 +54,7 @@ func TestGet(t *testing.T) {
 		}
 		return nil, fmt.Errorf("%s not exist", key)
 	}))
+	defer DeleteGroup("scores")
 	for k, v := range db {
 		//这里取首先肯定是取不到，取不到就会调用上面的回调函数取db中取
 		if view, err := gac.Get(k); err != nil || view.String() != v {
@@ -52,3 +70,111 @@ func TestGet(t *testing.T) {
 		t.Fatalf("the value of unknow should be empty, but %s got", view)
 	}
 }
+
+func TestGroupLifecycle(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	r := NewRegistry()
+	var created []string
+	r.RegisterNewGroupHook(func(g *Group) {
+		//回调中可以访问Registry
+		if r.GetGroup(g.Name()) == g {
+			created = append(created, g.Name())
+		}
+	})
+	g1 := r.NewGroup("lifecycle1", 2<<10, getter)
+	r.NewGroup("lifecycle2", 2<<10, getter)
+	if !reflect.DeepEqual(created, []string{"lifecycle1", "lifecycle2"}) {
+		t.Fatalf("new group hook not called, got %v", created)
+	}
+	if _, err := r.NewGroupWithOptions("lifecycle1", 2<<10, getter); err == nil {
+		t.Fatalf("duplicate group should fail")
+	}
+	var names []string
+	for _, g := range r.ListGroups() {
+		names = append(names, g.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"lifecycle1", "lifecycle2"}) {
+		t.Fatalf("list groups fail, got %v", names)
+	}
+
+	g1.Get("Tom")
+	g1.Clear()
+	if _, ok := g1.mainCache.get("Tom"); ok {
+		t.Fatalf("Tom should be cleared")
+	}
+	r.DeleteGroup("lifecycle1")
+	r.DeleteGroup("lifecycle2")
+	if r.GetGroup("lifecycle1") != nil || len(r.ListGroups()) != 0 {
+		t.Fatalf("delete group fail")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r1, r2 := NewRegistry(), NewRegistry()
+	g1 := r1.NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("r1"), nil
+	}))
+	g2 := r2.NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("r2"), nil
+	}))
+	if GetGroup("scores") != nil || r1.GetGroup("scores") != g1 || r2.GetGroup("scores") != g2 {
+		t.Fatalf("registries should be independent")
+	}
+	if v, _ := g1.Get("Tom"); v.String() != "r1" {
+		t.Fatalf("expect r1, but got %s", v)
+	}
+	if v, _ := g2.Get("Tom"); v.String() != "r2" {
+		t.Fatalf("expect r2, but got %s", v)
+	}
+	//之后创建的Group也会使用Registry的PeerPicker
+	peers := NewHTTPPool("http://localhost:8001", WithRegistry(r1))
+	r1.RegisterPeers(peers)
+	if g1.peers != peers || r1.NewGroup("names", 2<<10, GetterFunc(nil)).peers != peers || g2.peers != nil {
+		t.Fatalf("register peers fail")
+	}
+	//'_'开头的名字保留给节点之间的控制请求
+	if _, err := r1.NewGroupWithOptions("_dump", 2<<10, GetterFunc(nil)); err == nil {
+		t.Fatalf("reserved group name should fail")
+	}
+}
+
+func TestMultiNode(t *testing.T) {
+	//两个节点各自拥有独立的Registry和HTTPPool
+	var addrs []string
+	var pools []*HTTPPool
+	var loads [2]int
+	for i := 0; i < 2; i++ {
+		i := i
+		r := NewRegistry()
+		r.NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			loads[i]++
+			return []byte(key), nil
+		}))
+		server := httptest.NewUnstartedServer(nil)
+		addr := "http://" + server.Listener.Addr().String()
+		pool := NewHTTPPool(addr, WithRegistry(r))
+		r.RegisterPeers(pool)
+		server.Config.Handler = pool
+		server.Start()
+		defer server.Close()
+		addrs = append(addrs, addr)
+		pools = append(pools, pool)
+	}
+	for _, pool := range pools {
+		pool.Set(addrs...)
+	}
+	//每个key只会在它所属的节点加载一次
+	for i := 0; i < 20; i++ {
+		key := strconv.Itoa(i)
+		for _, pool := range pools {
+			if v, err := pool.registry.GetGroup("scores").Get(key); err != nil || v.String() != key {
+				t.Fatalf("get %s fail: %v", key, err)
+			}
+		}
+	}
+	if loads[0]+loads[1] != 20 || loads[0] == 0 || loads[1] == 0 {
+		t.Fatalf("each key should be loaded once by its owner, but got %v", loads)
+	}
+}
@@ -0,0 +1,198 @@
+//gacachetest 在一个进程中启动多个gacache节点,方便测试节点之间的交互
+//每个节点拥有独立的Registry和HTTPPool,通过httptest.Server通信,可以模拟节点宕机,网络分区和慢节点
+package gacachetest
+
+import (
+	"fmt"
+	"gacache"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Cluster 一组互相连接的节点
+type Cluster struct {
+	Nodes []*Node
+
+	mu         sync.RWMutex
+	partitions map[[2]string]bool //被分区隔开的节点对
+}
+
+//Node 集群中的一个节点
+type Node struct {
+	Addr     string //节点地址 eg. http://127.0.0.1:34567
+	Registry *gacache.Registry
+	Pool     *gacache.HTTPPool
+
+	cluster *Cluster
+	server  *httptest.Server
+	mu      sync.RWMutex
+	picker  gacache.PeerPicker //Group实际使用的PeerPicker,默认是Pool
+	killed  int32              //是否宕机
+	latency int64              //处理请求前的延迟(纳秒)
+}
+
+//启动n个节点,setup在HTTPPool创建之前调用,用于在节点的Registry中创建Group
+func NewCluster(n int, setup func(node *Node), opts ...gacache.HTTPPoolOption) *Cluster {
+	c := &Cluster{partitions: make(map[[2]string]bool)}
+	addrs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		node := &Node{cluster: c, Registry: gacache.NewRegistry()}
+		node.server = httptest.NewUnstartedServer(http.HandlerFunc(node.serveHTTP))
+		node.Addr = "http://" + node.server.Listener.Addr().String()
+		if setup != nil {
+			setup(node)
+		}
+		nodeOpts := append([]gacache.HTTPPoolOption{
+			gacache.WithRegistry(node.Registry),
+			gacache.WithTransport(&transport{from: node, base: http.DefaultTransport}),
+		}, opts...)
+		node.Pool = gacache.NewHTTPPool(node.Addr, nodeOpts...)
+		node.picker = node.Pool
+		node.Registry.RegisterPeers(node)
+		node.server.Start()
+		c.Nodes = append(c.Nodes, node)
+		addrs = append(addrs, node.Addr)
+	}
+	for _, node := range c.Nodes {
+		node.Pool.Set(addrs...)
+	}
+	return c
+}
+
+//关闭所有节点
+func (c *Cluster) Close() {
+	for _, node := range c.Nodes {
+		node.Pool.Close()
+	}
+	for _, node := range c.Nodes {
+		node.server.Close()
+	}
+}
+
+//根据一致性Hash返回key所属的节点
+func (c *Cluster) Owner(key string) *Node {
+	for _, node := range c.Nodes {
+		if _, ok := node.Pool.PickPeer(key); !ok {
+			return node
+		}
+	}
+	return nil
+}
+
+//返回除了node之外的所有节点
+func (c *Cluster) Others(node *Node) []*Node {
+	var others []*Node
+	for _, n := range c.Nodes {
+		if n != node {
+			others = append(others, n)
+		}
+	}
+	return others
+}
+
+//返回除了node之外的第一个节点,比如key所属节点之外的节点
+func (c *Cluster) Other(node *Node) *Node {
+	return c.Others(node)[0]
+}
+
+//隔开a和b,两者之间的请求都会失败
+func (c *Cluster) Partition(a, b *Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.partitions[[2]string{a.Addr, b.Addr}] = true
+	c.partitions[[2]string{b.Addr, a.Addr}] = true
+}
+
+//恢复a和b之间的网络
+func (c *Cluster) Heal(a, b *Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.partitions, [2]string{a.Addr, b.Addr})
+	delete(c.partitions, [2]string{b.Addr, a.Addr})
+}
+
+func (c *Cluster) partitioned(from, to string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.partitions[[2]string{from, to}]
+}
+
+//获取节点中的Group
+func (n *Node) Group(name string) *gacache.Group {
+	return n.Registry.GetGroup(name)
+}
+
+//替换节点中Group使用的PeerPicker,比如包装成FaultPicker
+func (n *Node) SetPicker(picker gacache.PeerPicker) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.picker = picker
+}
+
+//Node本身作为PeerPicker注册到Registry中,方便在测试过程中替换
+func (n *Node) PickPeer(key string) (gacache.PeerGetter, bool) {
+	n.mu.RLock()
+	picker := n.picker
+	n.mu.RUnlock()
+	return picker.PickPeer(key)
+}
+
+//实际使用的PeerPicker实现了PeerLister的时候返回除了自己之外的所有节点,用于广播
+func (n *Node) Peers() []gacache.PeerGetter {
+	n.mu.RLock()
+	picker := n.picker
+	n.mu.RUnlock()
+	if l, ok := picker.(gacache.PeerLister); ok {
+		return l.Peers()
+	}
+	return nil
+}
+
+var _ gacache.PeerLister = (*Node)(nil)
+
+//模拟节点宕机,发往该节点的请求都会失败
+func (n *Node) Kill() {
+	atomic.StoreInt32(&n.killed, 1)
+}
+
+//恢复宕机的节点
+func (n *Node) Revive() {
+	atomic.StoreInt32(&n.killed, 0)
+}
+
+func (n *Node) Killed() bool {
+	return atomic.LoadInt32(&n.killed) == 1
+}
+
+//模拟慢节点,该节点处理每个请求之前都会等待d
+func (n *Node) Slow(d time.Duration) {
+	atomic.StoreInt64(&n.latency, int64(d))
+}
+
+func (n *Node) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if n.Killed() {
+		//直接断开连接,客户端会收到EOF
+		panic(http.ErrAbortHandler)
+	}
+	if d := atomic.LoadInt64(&n.latency); d > 0 {
+		time.Sleep(time.Duration(d))
+	}
+	n.Pool.ServeHTTP(w, req)
+}
+
+//节点发起请求时使用的Transport,用于模拟网络分区
+type transport struct {
+	from *Node
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	to := "http://" + req.URL.Host
+	if t.from.cluster.partitioned(t.from.Addr, to) {
+		return nil, fmt.Errorf("network partition between %s and %s", t.from.Addr, to)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,487 @@
+package gacachetest
+
+import (
+	"context"
+	"errors"
+	"gacache"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//每个节点创建一个scores Group,loads记录每个节点从数据源加载的次数
+func newScoresCluster(n int) (*Cluster, *loadCounter) {
+	counter := &loadCounter{loads: make(map[string]int)}
+	c := NewCluster(n, func(node *Node) {
+		addr := node.Addr
+		node.Registry.NewGroup("scores", 2<<10, gacache.GetterFunc(func(key string) ([]byte, error) {
+			counter.add(addr)
+			return []byte(key), nil
+		}))
+	})
+	return c, counter
+}
+
+//在node上访问key直到远程获取的QPS达到阈值,key存入hotCache并且命中一次
+func promote(t *testing.T, node *Node, group, key string) {
+	t.Helper()
+	g := node.Group(group)
+	hits := g.Stats.HotCacheHits.Get()
+	for i := 0; i < 100 && g.Stats.HotCacheHits.Get() == hits; i++ {
+		if _, err := g.Get(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g.Stats.HotCacheHits.Get() == hits {
+		t.Fatalf("%s should be promoted to hotCache on %s", key, node.Addr)
+	}
+}
+
+type loadCounter struct {
+	mu    sync.Mutex
+	loads map[string]int
+}
+
+func (c *loadCounter) add(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loads[addr]++
+}
+
+func (c *loadCounter) get(addr string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loads[addr]
+}
+
+func TestPeerLoad(t *testing.T) {
+	c, counter := newScoresCluster(3)
+	defer c.Close()
+	for i := 0; i < 30; i++ {
+		key := strconv.Itoa(i)
+		for _, node := range c.Nodes {
+			if v, err := node.Group("scores").Get(key); err != nil || v.String() != key {
+				t.Fatalf("get %s from %s fail: %v", key, node.Addr, err)
+			}
+		}
+	}
+	//每个key只会被所属的节点加载一次
+	total := 0
+	for _, node := range c.Nodes {
+		total += counter.get(node.Addr)
+	}
+	if total != 30 {
+		t.Fatalf("each key should be loaded once, but loaded %d times", total)
+	}
+}
+
+func TestHotCachePromotion(t *testing.T) {
+	c, _ := newScoresCluster(2)
+	defer c.Close()
+	key := "Tom"
+	owner := c.Owner(key)
+	other := c.Other(owner)
+	g := other.Group("scores")
+	var peerLoads, promotes int64
+	g.Subscribe(func(e gacache.Event) {
+		switch {
+		case e.Type == gacache.EventPeerLoad && e.Peer == owner.Addr:
+			atomic.AddInt64(&peerLoads, 1)
+		case e.Type == gacache.EventHotPromote && e.Key == key:
+			atomic.AddInt64(&promotes, 1)
+		}
+	})
+	//远程获取的QPS达到阈值之后会存入hotCache
+	promote(t, other, "scores", key)
+	if atomic.LoadInt64(&promotes) != 1 {
+		t.Fatalf("%s should be promoted once", key)
+	}
+	if atomic.LoadInt64(&peerLoads) != g.Stats.PeerLoads.Get() {
+		t.Fatalf("each peer load should emit an event")
+	}
+	//之后的请求直接从hotCache获取,不再访问远程节点
+	loads := g.Stats.PeerLoads.Get()
+	for i := 0; i < 5; i++ {
+		g.Get(key)
+	}
+	if g.Stats.PeerLoads.Get() != loads {
+		t.Fatalf("hotCache should avoid peer loads, got %d", g.Stats.PeerLoads.Get()-loads)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	c, counter := newScoresCluster(2)
+	defer c.Close()
+	key := "Tom"
+	owner := c.Owner(key)
+	other := c.Other(owner)
+	owner.Kill()
+	if v, err := other.Group("scores").Get(key); err != nil || v.String() != key {
+		t.Fatalf("should fall back to local getter when owner is down: %v", err)
+	}
+	if counter.get(other.Addr) != 1 || other.Group("scores").Stats.PeerErrors.Get() != 1 {
+		t.Fatalf("expect a peer error and a local load")
+	}
+	owner.Revive()
+
+	//网络分区同样会降级到本地加载
+	key = "Jack"
+	owner = c.Owner(key)
+	other = c.Other(owner)
+	c.Partition(owner, other)
+	before := counter.get(owner.Addr)
+	if _, err := other.Group("scores").Get(key); err != nil || counter.get(owner.Addr) != before {
+		t.Fatalf("partitioned request should not reach the owner")
+	}
+	c.Heal(owner, other)
+	other.Group("scores").Clear()
+	if _, err := other.Group("scores").Get(key); err != nil || counter.get(owner.Addr) != before+1 {
+		t.Fatalf("healed request should reach the owner")
+	}
+}
+
+func TestSlowNode(t *testing.T) {
+	c, _ := newScoresCluster(2)
+	defer c.Close()
+	key := "Tom"
+	owner := c.Owner(key)
+	owner.Slow(100 * time.Millisecond)
+	for _, node := range c.Nodes {
+		if node != owner {
+			start := time.Now()
+			node.Group("scores").Get(key)
+			if time.Since(start) < 100*time.Millisecond {
+				t.Fatalf("request to slow node should be delayed")
+			}
+		}
+	}
+}
+
+func TestErrorPropagation(t *testing.T) {
+	var localLoads int32
+	c := NewCluster(2, func(node *Node) {
+		node.Registry.NewGroup("scores", 2<<10, gacache.GetterFunc(func(key string) ([]byte, error) {
+			atomic.AddInt32(&localLoads, 1)
+			switch key {
+			case "busy":
+				return nil, gacache.ErrOverloaded
+			}
+			return nil, gacache.NewError(gacache.CodeNotFound, "%s not exist", key)
+		}), gacache.WithPeerFallback(func(err error) bool {
+			//只有远程节点过载的时候才从本地加载
+			return errors.Is(err, gacache.ErrOverloaded)
+		}))
+	})
+	defer c.Close()
+	for _, key := range []string{"Tom", "busy"} {
+		owner := c.Owner(key)
+		for _, node := range c.Nodes {
+			if node == owner {
+				continue
+			}
+			atomic.StoreInt32(&localLoads, 0)
+			_, err := node.Group("scores").Get(key)
+			switch key {
+			case "Tom":
+				//远程节点返回key不存在,不会再从本地加载
+				if !errors.Is(err, gacache.ErrNotFound) || err.Error() != "Tom not exist" || atomic.LoadInt32(&localLoads) != 1 {
+					t.Fatalf("expect not found from owner, but got %v", err)
+				}
+			case "busy":
+				//远程节点过载,降级到本地加载
+				if !errors.Is(err, gacache.ErrOverloaded) || atomic.LoadInt32(&localLoads) != 2 {
+					t.Fatalf("expect fallback after overloaded, but got %v", err)
+				}
+			}
+		}
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	c, counter := newScoresCluster(3)
+	defer c.Close()
+	keys := make([]string, 30)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	//每个节点只加载属于自己的key
+	total := 0
+	for _, node := range c.Nodes {
+		n, err := node.Group("scores").WarmUp(keys, 4)
+		if err != nil || n != counter.get(node.Addr) {
+			t.Fatalf("warm up %s fail: %d %v", node.Addr, n, err)
+		}
+		total += n
+	}
+	if total != 30 {
+		t.Fatalf("each key should be warmed up once, but loaded %d times", total)
+	}
+	//预热之后不会再访问数据源
+	for _, key := range keys {
+		owner := c.Owner(key)
+		before := counter.get(owner.Addr)
+		if _, err := owner.Group("scores").Get(key); err != nil || counter.get(owner.Addr) != before {
+			t.Fatalf("%s should be warmed up on %s", key, owner.Addr)
+		}
+	}
+}
+
+func TestWarmUpFromPeers(t *testing.T) {
+	c, counter := newScoresCluster(2)
+	defer c.Close()
+	a, b := c.Nodes[0], c.Nodes[1]
+	//模拟扩容:b加入之前所有的key都在a上
+	a.Pool.Set(a.Addr)
+	for i := 0; i < 30; i++ {
+		a.Group("scores").Get(strconv.Itoa(i))
+	}
+	a.Pool.Set(a.Addr, b.Addr)
+	n, err := b.Pool.WarmUpFromPeers(b.Group("scores"))
+	if err != nil || n == 0 {
+		t.Fatalf("warm up from peers fail: %d %v", n, err)
+	}
+	filled := 0
+	for i := 0; i < 30; i++ {
+		key := strconv.Itoa(i)
+		if c.Owner(key) != b {
+			continue
+		}
+		filled++
+		if v, err := b.Group("scores").Get(key); err != nil || v.String() != key {
+			t.Fatalf("get %s fail: %v", key, err)
+		}
+	}
+	//b的数据都是从a迁移过来的,不需要访问数据源
+	if filled != n || counter.get(b.Addr) != 0 {
+		t.Fatalf("expect %d keys from peers, got %d, loads %d", filled, n, counter.get(b.Addr))
+	}
+}
+
+func TestChunkedPeerLoad(t *testing.T) {
+	value := strings.Repeat("0123456789", 100)
+	c := NewCluster(2, func(node *Node) {
+		node.Registry.NewGroup("blobs", 2<<10, gacache.GetterFunc(func(key string) ([]byte, error) {
+			return []byte(value), nil
+		}), gacache.WithChunkSize(64))
+	})
+	defer c.Close()
+	for _, node := range c.Nodes {
+		g := node.Group("blobs")
+		if v, err := g.Get("Tom"); err != nil || v.String() != value {
+			t.Fatalf("get Tom from %s fail: %v", node.Addr, err)
+		}
+		r, err := g.GetReader("Tom")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := ioutil.ReadAll(r); err != nil || string(b) != value {
+			t.Fatalf("read Tom from %s fail: %v", node.Addr, err)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		written = map[string]string{}
+	)
+	c := NewCluster(3, func(node *Node) {
+		node.Registry.NewGroup("scores", 2<<10, gacache.GetterFunc(func(key string) ([]byte, error) {
+			return []byte("630"), nil
+		}), gacache.WithSetter(gacache.SetterFunc(func(ctx context.Context, key string, value []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			written[key] = string(value)
+			return nil
+		})))
+	})
+	defer c.Close()
+	key := "Tom"
+	owner := c.Owner(key)
+	other := c.Other(owner)
+	//other中保存热点副本
+	promote(t, other, "scores", key)
+	//从任意节点写入都由owner写入数据源,并删除其他节点的副本
+	for _, node := range c.Nodes {
+		if node == other {
+			continue
+		}
+		value := "589 from " + node.Addr
+		if err := node.Group("scores").Set(context.Background(), key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		got := written[key]
+		mu.Unlock()
+		if got != value {
+			t.Fatalf("setter should be called by owner, got %q", got)
+		}
+		for _, n := range c.Nodes {
+			if v, err := n.Group("scores").Get(key); err != nil || v.String() != value {
+				t.Fatalf("get %s from %s should return new value, got %q", key, n.Addr, v.String())
+			}
+		}
+	}
+	if owner.Group("scores").Stats.Sets.Get() != 1 || owner.Group("scores").Stats.LocalLoads.Get() != 1 {
+		t.Fatalf("owner should not reload after set")
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	c := NewCluster(3, func(node *Node) {
+		node.Registry.NewGroup("scores", 2<<10, gacache.GetterFunc(func(key string) ([]byte, error) {
+			return []byte("630"), nil
+		}))
+	})
+	defer c.Close()
+	key := "Tom"
+	owner := c.Owner(key)
+	other := c.Other(owner)
+	ctx := context.Background()
+	//从其他节点读取的版本号和owner一致,由owner比较和写入
+	_, meta, err := other.Group("scores").GetWithMeta(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, m, _ := owner.Group("scores").GetWithMeta(key); m.Version != meta.Version {
+		t.Fatalf("version from peer should be %d, got %d", m.Version, meta.Version)
+	}
+	version, err := other.Group("scores").CompareAndSet(ctx, key, meta.Version, []byte("589"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, m, _ := owner.Group("scores").GetWithMeta(key); m.Version != version {
+		t.Fatalf("owner should return new version %d, got %d", version, m.Version)
+	}
+	//冲突的错误类型需要传递到调用的节点
+	if _, err := other.Group("scores").CompareAndSet(ctx, key, meta.Version, []byte("0")); !errors.Is(err, gacache.ErrConflict) {
+		t.Fatalf("stale cas should conflict, got %v", err)
+	}
+	if owner.Group("scores").Stats.Conflicts.Get() != 1 {
+		t.Fatalf("conflict should be counted by owner")
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	var current int64 = 1
+	c := NewCluster(2, func(node *Node) {
+		node.Registry.NewGroup("blobs", 2<<10, gacache.GetterFunc(func(key string) ([]byte, error) {
+			n := atomic.LoadInt64(&current)
+			return []byte(strings.Repeat(strconv.FormatInt(n, 10), 100)), nil
+		}), gacache.WithChunkSize(64), gacache.WithTTL(time.Minute), gacache.WithStaleWhileRevalidate(20*time.Millisecond))
+	})
+	defer c.Close()
+	key := "Tom"
+	owner := c.Owner(key)
+	other := c.Other(owner)
+	g := other.Group("blobs")
+	promote(t, other, "blobs", key)
+	//等待hotCache中的副本软过期之后在后台刷新
+	refresh := func(stat *gacache.AtomicInt, want int64) {
+		deadline := time.Now().Add(time.Second)
+		for stat.Get() < want && time.Now().Before(deadline) {
+			time.Sleep(30 * time.Millisecond)
+			g.Get(key)
+		}
+		if stat.Get() < want {
+			t.Fatalf("stale copy should be revalidated")
+		}
+	}
+	//owner的数据没有变化,不重新传输
+	refresh(&g.Stats.PeerNotModified, 1)
+	if v, _ := g.Get(key); v.String() != strings.Repeat("1", 100) {
+		t.Fatalf("hot copy should be kept, got %q", v.String())
+	}
+	//数据源的数据变化之后owner重新加载,版本号变化,刷新的时候返回新值
+	peerLoads := g.Stats.PeerLoads.Get()
+	atomic.StoreInt64(&current, 2)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if v, _ := g.Get(key); v.String() == strings.Repeat("2", 100) {
+			break
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+	if v, _ := g.Get(key); v.String() != strings.Repeat("2", 100) || g.Stats.PeerLoads.Get() == peerLoads {
+		t.Fatalf("hot copy should be replaced, got %q", v.String())
+	}
+}
+
+func TestBumpNamespace(t *testing.T) {
+	c, counter := newScoresCluster(3)
+	defer c.Close()
+	key := "tenant1:Tom"
+	owner := c.Owner(key)
+	other := c.Other(owner)
+	promote(t, other, "scores", key)
+	//从任意节点BumpNamespace都会通知所有节点,hotCache中的副本和owner中的数据都需要重新加载
+	ctx := context.Background()
+	if err := other.Group("scores").BumpNamespace(ctx, "tenant1:"); err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range c.Nodes {
+		if node.Group("scores").Generation(key) != other.Group("scores").Generation(key) {
+			t.Fatalf("generation should be propagated to %s", node.Addr)
+		}
+	}
+	peerLoads := other.Group("scores").Stats.PeerLoads.Get()
+	if _, err := other.Group("scores").Get(key); err != nil {
+		t.Fatal(err)
+	}
+	if other.Group("scores").Stats.PeerLoads.Get() != peerLoads+1 || counter.get(owner.Addr) != 2 {
+		t.Fatalf("%s should be reloaded after bump", key)
+	}
+
+	//通知失败的时候,owner在下一次请求中跟着更新
+	c.Partition(other, owner)
+	if err := other.Group("scores").BumpNamespace(ctx, ""); err == nil {
+		t.Fatalf("bump should fail to reach %s", owner.Addr)
+	}
+	c.Heal(other, owner)
+	if _, err := other.Group("scores").Get(key); err != nil {
+		t.Fatal(err)
+	}
+	if owner.Group("scores").Generation(key) != other.Group("scores").Generation(key) || counter.get(owner.Addr) != 3 {
+		t.Fatalf("owner should catch up with generation from request")
+	}
+}
+
+func TestInvalidationPubSub(t *testing.T) {
+	c := NewCluster(3, func(node *Node) {
+		node.Registry.NewGroup("scores", 2<<10, gacache.GetterFunc(func(key string) ([]byte, error) {
+			return []byte("630"), nil
+		}))
+	})
+	defer c.Close()
+	for _, node := range c.Nodes {
+		node.Registry.RegisterBroker(node.Pool)
+	}
+	key := "Tom"
+	owner := c.Owner(key)
+	others := c.Others(owner)
+	//其他节点都保存热点副本
+	for _, node := range others {
+		promote(t, node, "scores", key)
+	}
+	//owner写入之后发布失效通知,其他节点通过长轮询收到之后删除副本
+	if err := owner.Group("scores").Set(context.Background(), key, []byte("589")); err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range others {
+		g := node.Group("scores")
+		deadline := time.Now().Add(time.Second)
+		for g.Stats.Invalidations.Get() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if g.Stats.Invalidations.Get() != 1 {
+			t.Fatalf("hot copy on %s should be invalidated", node.Addr)
+		}
+		if v, err := g.Get(key); err != nil || v.String() != "589" {
+			t.Fatalf("get %s from %s should return new value, got %q", key, node.Addr, v.String())
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package gacachetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gacache"
+	pb "gacache/gacachepb"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+//Faults 注入的故障,概率的取值范围都是[0,1]
+type Faults struct {
+	Latency     time.Duration //每次请求前的延迟
+	ErrorRate   float64       //请求直接失败的概率
+	DropRate    float64       //请求到达了远程节点,但是响应丢失的概率
+	CorruptRate float64       //响应的protobuf被破坏的概率
+}
+
+//FaultStats 已经注入的故障次数
+type FaultStats struct {
+	Errors      gacache.AtomicInt
+	Drops       gacache.AtomicInt
+	Corruptions gacache.AtomicInt
+	Partitions  gacache.AtomicInt
+}
+
+//FaultPicker 包装一个PeerPicker,对它选出的PeerGetter注入故障
+//远程节点的名字通过PeerGetter的String方法获取(HTTPPool的PeerGetter返回节点地址)
+//PeerGetter同时实现了PeerStreamer,PeerSetter和PeerNamespacer的时候,这些请求也会注入同样的故障
+type FaultPicker struct {
+	self   string
+	picker gacache.PeerPicker
+	Stats  FaultStats
+
+	mu         sync.Mutex
+	faults     Faults
+	partitions map[string]bool //与self隔开的节点
+	rand       *rand.Rand
+}
+
+func NewFaultPicker(self string, picker gacache.PeerPicker) *FaultPicker {
+	return &FaultPicker{
+		self:       self,
+		picker:     picker,
+		partitions: make(map[string]bool),
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+//设置注入的故障
+func (p *FaultPicker) SetFaults(f Faults) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.faults = f
+}
+
+//设置随机数种子,方便复现
+func (p *FaultPicker) Seed(seed int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rand = rand.New(rand.NewSource(seed))
+}
+
+//隔开self和names中的节点
+func (p *FaultPicker) Partition(names ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range names {
+		p.partitions[name] = true
+	}
+}
+
+//恢复self和names中的节点之间的网络
+func (p *FaultPicker) Heal(names ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range names {
+		delete(p.partitions, name)
+	}
+}
+
+func (p *FaultPicker) PickPeer(key string) (gacache.PeerGetter, bool) {
+	peer, ok := p.picker.PickPeer(key)
+	if !ok {
+		return nil, false
+	}
+	return p.wrap(peer), true
+}
+
+//被包装的PeerPicker实现了PeerLister的时候返回注入故障之后的所有节点
+func (p *FaultPicker) Peers() []gacache.PeerGetter {
+	l, ok := p.picker.(gacache.PeerLister)
+	if !ok {
+		return nil
+	}
+	var peers []gacache.PeerGetter
+	for _, peer := range l.Peers() {
+		peers = append(peers, p.wrap(peer))
+	}
+	return peers
+}
+
+var (
+	_ gacache.PeerPicker = (*FaultPicker)(nil)
+	_ gacache.PeerLister = (*FaultPicker)(nil)
+)
+
+//远程节点支持的可选接口都需要经过故障注入,否则调用者会绕过故障
+type fullPeer interface {
+	gacache.PeerGetter
+	gacache.PeerStreamer
+	gacache.PeerSetter
+	gacache.PeerNamespacer
+}
+
+func (p *FaultPicker) wrap(peer gacache.PeerGetter) gacache.PeerGetter {
+	g := &faultGetter{picker: p, name: gacache.PeerName(peer), peer: peer}
+	if full, ok := peer.(fullPeer); ok {
+		return &faultPeer{faultGetter: g, full: full}
+	}
+	return g
+}
+
+//按照概率判断是否发生故障
+func (p *FaultPicker) hit(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rand.Float64() < rate
+}
+
+func (p *FaultPicker) state(name string) (Faults, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.faults, p.partitions[name]
+}
+
+type faultGetter struct {
+	picker *FaultPicker
+	name   string
+	peer   gacache.PeerGetter
+}
+
+func (g *faultGetter) String() string {
+	return g.name
+}
+
+func (g *faultGetter) Get(in *pb.Request, out *pb.Response) error {
+	return g.call(out, func(res *pb.Response) error {
+		return g.peer.Get(in, res)
+	})
+}
+
+//请求发出之前的故障: 延迟,网络分区和直接失败
+func (g *faultGetter) before() (Faults, error) {
+	p := g.picker
+	f, partitioned := p.state(g.name)
+	if f.Latency > 0 {
+		time.Sleep(f.Latency)
+	}
+	if partitioned {
+		p.Stats.Partitions.Add(1)
+		return f, fmt.Errorf("network partition between %s and %s", p.self, g.name)
+	}
+	if p.hit(f.ErrorRate) {
+		p.Stats.Errors.Add(1)
+		return f, fmt.Errorf("injected error from %s", g.name)
+	}
+	return f, nil
+}
+
+//请求到达远程节点之后响应丢失
+func (g *faultGetter) drop(f Faults) error {
+	if g.picker.hit(f.DropRate) {
+		g.picker.Stats.Drops.Add(1)
+		return fmt.Errorf("response from %s dropped", g.name)
+	}
+	return nil
+}
+
+//对返回protobuf响应的请求注入所有的故障
+func (g *faultGetter) call(out *pb.Response, fn func(res *pb.Response) error) error {
+	p := g.picker
+	f, err := g.before()
+	if err != nil {
+		return err
+	}
+	res := &pb.Response{}
+	if err := fn(res); err != nil {
+		return err
+	}
+	if err := g.drop(f); err != nil {
+		return err
+	}
+	body, err := proto.Marshal(res)
+	if err != nil {
+		return err
+	}
+	if p.hit(f.CorruptRate) {
+		p.Stats.Corruptions.Add(1)
+		body = corrupt(body)
+	}
+	//和httpGetter一样解码响应
+	if err := proto.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+var _ gacache.PeerGetter = (*faultGetter)(nil)
+
+//远程节点支持所有可选接口时使用的包装
+type faultPeer struct {
+	*faultGetter
+	full fullPeer
+}
+
+func (g *faultPeer) GetStream(in *pb.Request) (io.ReadCloser, error) {
+	f, err := g.before()
+	if err != nil {
+		return nil, err
+	}
+	body, err := g.full.GetStream(in)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.drop(f); err != nil {
+		body.Close()
+		return nil, err
+	}
+	if !g.picker.hit(f.CorruptRate) {
+		return body, nil
+	}
+	g.picker.Stats.Corruptions.Add(1)
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	stream := &faultStream{r: bytes.NewReader(corrupt(b))}
+	if v, ok := body.(interface{ Version() uint64 }); ok {
+		stream.v = v.Version()
+	}
+	return stream, nil
+}
+
+func (g *faultPeer) Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error {
+	return g.call(out, func(res *pb.Response) error {
+		return g.full.Set(ctx, in, res)
+	})
+}
+
+func (g *faultPeer) Invalidate(ctx context.Context, in *pb.Request) error {
+	return g.notify(func() error {
+		return g.full.Invalidate(ctx, in)
+	})
+}
+
+func (g *faultPeer) BumpNamespace(ctx context.Context, in *pb.Request) error {
+	return g.notify(func() error {
+		return g.full.BumpNamespace(ctx, in)
+	})
+}
+
+//没有响应内容的请求只注入延迟,分区,失败和丢失
+func (g *faultPeer) notify(fn func() error) error {
+	f, err := g.before()
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return g.drop(f)
+}
+
+var _ fullPeer = (*faultPeer)(nil)
+
+//被截断的流式响应,读完剩下的数据之后返回io.ErrUnexpectedEOF,保留原来的版本号
+type faultStream struct {
+	r *bytes.Reader
+	v uint64
+}
+
+func (s *faultStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (s *faultStream) Close() error {
+	return nil
+}
+
+func (s *faultStream) Version() uint64 {
+	return s.v
+}
+
+//截断最后一个字节,让length-delimited的字段不完整
+func corrupt(body []byte) []byte {
+	if len(body) == 0 {
+		return []byte{0xff}
+	}
+	return body[:len(body)-1]
+}
@@ -0,0 +1,187 @@
+package gacachetest
+
+import (
+	"context"
+	"gacache"
+	pb "gacache/gacachepb"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//让第一个节点的请求都经过FaultPicker
+func newFaultCluster() (*Cluster, *loadCounter, *Node, *FaultPicker) {
+	c, counter := newScoresCluster(3)
+	node := c.Nodes[0]
+	faults := NewFaultPicker(node.Addr, node.Pool)
+	faults.Seed(1)
+	node.SetPicker(faults)
+	return c, counter, node, faults
+}
+
+//返回不属于node的key
+func remoteKeys(c *Cluster, node *Node, n int) []string {
+	var keys []string
+	for i := 0; len(keys) < n; i++ {
+		key := strconv.Itoa(i)
+		if c.Owner(key) != node {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestFaultFallback(t *testing.T) {
+	testCase := map[string]Faults{
+		"error":   {ErrorRate: 1},
+		"drop":    {DropRate: 1},
+		"corrupt": {CorruptRate: 1},
+	}
+	for name, f := range testCase {
+		c, counter, node, faults := newFaultCluster()
+		faults.SetFaults(f)
+		keys := remoteKeys(c, node, 5)
+		for _, key := range keys {
+			if v, err := node.Group("scores").Get(key); err != nil || v.String() != key {
+				t.Fatalf("%s: get %s should fall back to local getter: %v", name, key, err)
+			}
+		}
+		//所有的key都降级到本地加载
+		if n := counter.get(node.Addr); n != len(keys) {
+			t.Fatalf("%s: expect %d local loads, but got %d", name, len(keys), n)
+		}
+		injected := faults.Stats.Errors.Get() + faults.Stats.Drops.Get() + faults.Stats.Corruptions.Get()
+		if injected != int64(len(keys)) {
+			t.Fatalf("%s: expect %d faults, but got %d", name, len(keys), injected)
+		}
+		c.Close()
+	}
+}
+
+func TestFaultTruncatedStream(t *testing.T) {
+	value := strings.Repeat("0123456789", 100)
+	c := NewCluster(2, func(node *Node) {
+		node.Registry.NewGroup("blobs", 2<<10, gacache.GetterFunc(func(key string) ([]byte, error) {
+			return []byte(value), nil
+		}), gacache.WithChunkSize(64))
+	})
+	defer c.Close()
+	node := c.Nodes[0]
+	faults := NewFaultPicker(node.Addr, node.Pool)
+	faults.SetFaults(Faults{CorruptRate: 1})
+	node.SetPicker(faults)
+	key := remoteKeys(c, node, 1)[0]
+	peer, ok := faults.PickPeer(key)
+	if !ok {
+		t.Fatalf("%s should be picked from remote peer", key)
+	}
+	body, err := peer.(gacache.PeerStreamer).GetStream(&pb.Request{Group: "blobs", Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	//截断的响应读取的时候返回错误,不会被当成完整的数据
+	if _, err := ioutil.ReadAll(body); err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated stream should fail with unexpected EOF, but got %v", err)
+	}
+	if v, err := node.Group("blobs").Get(key); err != nil || v.String() != value {
+		t.Fatalf("get %s should fall back to local getter: %v", key, err)
+	}
+}
+
+func TestFaultPartition(t *testing.T) {
+	c, counter, node, faults := newFaultCluster()
+	defer c.Close()
+	other := c.Nodes[1]
+	faults.Partition(other.Addr)
+	var key string
+	for _, k := range remoteKeys(c, node, 20) {
+		if c.Owner(k) == other {
+			key = k
+			break
+		}
+	}
+	node.Group("scores").Get(key)
+	if counter.get(other.Addr) != 0 || faults.Stats.Partitions.Get() != 1 {
+		t.Fatalf("partitioned request should not reach %s", other.Addr)
+	}
+	faults.Heal(other.Addr)
+	node.Group("scores").Clear()
+	node.Group("scores").Get(key)
+	if counter.get(other.Addr) != 1 {
+		t.Fatalf("healed request should reach %s", other.Addr)
+	}
+}
+
+//写入和广播请求同样经过故障注入
+func TestFaultPartitionWrite(t *testing.T) {
+	c, _, node, faults := newFaultCluster()
+	defer c.Close()
+	other := c.Nodes[1]
+	faults.Partition(other.Addr)
+	var key string
+	for _, k := range remoteKeys(c, node, 20) {
+		if c.Owner(k) == other {
+			key = k
+			break
+		}
+	}
+	ctx := context.Background()
+	if err := node.Group("scores").Set(ctx, key, []byte("630")); err == nil {
+		t.Fatalf("partitioned set should fail")
+	}
+	if v, _ := other.Group("scores").Get(key); v.String() != key || faults.Stats.Partitions.Get() != 1 {
+		t.Fatalf("partitioned set should not reach %s", other.Addr)
+	}
+	//广播给所有节点的时候只有被隔开的节点失败
+	if err := node.Group("scores").BumpNamespace(ctx, "ns:"); err == nil {
+		t.Fatalf("partitioned bump should fail")
+	}
+	if other.Group("scores").Generation("ns:1") != 0 || c.Nodes[2].Group("scores").Generation("ns:1") == 0 {
+		t.Fatalf("bump should only reach the healthy node")
+	}
+	faults.Heal(other.Addr)
+	if err := node.Group("scores").Set(ctx, key, []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := other.Group("scores").Get(key); v.String() != "630" {
+		t.Fatalf("healed set should reach %s, but got %s", other.Addr, v.String())
+	}
+}
+
+//大量并发请求在随机故障下都能返回,singleflight不会死锁
+func TestFaultNoDeadlock(t *testing.T) {
+	c, _, node, faults := newFaultCluster()
+	defer c.Close()
+	faults.SetFaults(Faults{
+		Latency:     5 * time.Millisecond,
+		ErrorRate:   0.3,
+		DropRate:    0.2,
+		CorruptRate: 0.2,
+	})
+	keys := remoteKeys(c, node, 10)
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 200; i++ {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				if v, err := node.Group("scores").Get(key); err != nil || v.String() != key {
+					t.Errorf("get %s fail: %v", key, err)
+				}
+			}(keys[i%len(keys)])
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("requests blocked under faults")
+	}
+}
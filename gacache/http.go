@@ -1,36 +1,86 @@
 package gacache
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"gacache/consistenthash"
 	pb "gacache/gacachepb"
 	"github.com/golang/protobuf/proto"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
 	defaultPath     = "/_gacache/"
 	defaultReplicas = 50
+	dumpPath        = "_dump"      //basePath/_dump/groupName 导出Group中的数据
+	namespacePath   = "_namespace" //basePath/_namespace/groupName 更新命名空间的代数
+	pollPath        = "_poll"      //basePath/_poll/?since=seq&epoch=epoch 长轮询失效通知
+	//长轮询的默认等待时间
+	defaultPollTimeout = 30 * time.Second
+	//轮询失败之后重试的间隔
+	pollRetryInterval = time.Second
+	//请求的Accept包含它的时候流式返回原始的value,不经过protobuf编码
+	streamContentType = "application/x-gacache-stream"
 )
 
 type HTTPPool struct {
-	self        string //自己的地址,包括ip:port
-	basePath    string //节点间通讯地址的前缀,默认是'/_gacache/'
+	self        string              //自己的地址,包括ip:port
+	basePath    string              //节点间通讯地址的前缀,默认是'/_gacache/'
+	replicas    int                 //虚拟节点倍数
+	hashFn      consistenthash.Hash //一致性Hash的hash函数
+	transport   http.RoundTripper   //请求远程节点使用的Transport
+	timeout     time.Duration       //请求远程节点的超时时间
+	registry    *Registry           //处理请求时从这里查找Group
+	pollTimeout time.Duration       //长轮询失效通知的最长等待时间
 	mu          sync.Mutex
 	peers       *consistenthash.Map    //一致性Hash算法
 	httpGetters map[string]*httpGetter //每个远程节点对应一个httpGetter(节点的ip:port/defaultPath)
+	//失效通知
+	log     *invalidationLog
+	subs    map[int]func(in *pb.Invalidation)
+	nextSub int
+	pollers map[string]context.CancelFunc //正在轮询的节点
+	closed  chan struct{}
 }
 
-func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:     self,
-		basePath: defaultPath,
+//新建HTTPPool,配置不合法的时候会panic
+func NewHTTPPool(self string, opts ...HTTPPoolOption) *HTTPPool {
+	p, err := NewHTTPPoolWithOptions(self, opts...)
+	if err != nil {
+		panic(err)
 	}
+	return p
+}
+
+//新建HTTPPool,配置不合法的时候返回error
+func NewHTTPPoolWithOptions(self string, opts ...HTTPPoolOption) (*HTTPPool, error) {
+	p := &HTTPPool{
+		self:        self,
+		basePath:    defaultPath,
+		replicas:    defaultReplicas,
+		registry:    DefaultRegistry,
+		pollTimeout: defaultPollTimeout,
+		log:         newInvalidationLog(),
+		subs:        make(map[int]func(in *pb.Invalidation)),
+		pollers:     make(map[string]context.CancelFunc),
+		closed:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
 func (p *HTTPPool) Log(format string, v ...interface{}) {
@@ -47,41 +97,322 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// 以‘/’为界限将groupName和key划分为2个part
 	parts := strings.SplitN(req.URL.Path[len(p.basePath):], "/", 2)
 	if len(parts) != 2 {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
+		return
+	}
+	switch parts[0] {
+	case dumpPath:
+		p.serveDump(w, req, parts[1])
+		return
+	case namespacePath:
+		p.serveNamespace(w, req, parts[1])
+		return
+	case pollPath:
+		p.servePoll(w, req)
 		return
 	}
 	groupName := parts[0]
 	key := parts[1]
-	group := GetGroup(groupName)
+	group := p.registry.GetGroup(groupName)
 	if group == nil {
-		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		writeError(w, NewError(CodeBadRequest, "no such group: %s", groupName))
 		return
 	}
-	view, err := group.Get(key)
+	switch req.Method {
+	case http.MethodPut:
+		p.serveSet(w, req, group, key)
+		return
+	case http.MethodDelete:
+		//key所属的节点更新了数据,删除hotCache中的副本
+		group.removeFrom(key, &group.hotCache)
+		return
+	}
+	//请求方的命名空间更新的时候跟着更新,然后再查找
+	q := req.URL.Query()
+	if gen, _ := strconv.ParseUint(q.Get("generation"), 10, 64); gen != 0 && validNamespace(q.Get("namespace")) {
+		group.ns.bump(q.Get("namespace"), gen)
+	}
+	v, err := group.lookup(key)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+	if v.v != 0 {
+		w.Header().Set("ETag", etag(v.v))
+		//请求方的副本没有变化,不需要重新传输
+		if parseETag(req.Header.Get("If-None-Match")) == v.v {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if accepts(req.Header.Get("Accept"), streamContentType) {
+		w.Header().Set("Content-Type", streamContentType)
+		if _, err := io.Copy(w, newChunkReader(v)); err != nil {
+			//已经开始写入body,只能断开连接让对方读取失败,而不是读到不完整的数据
+			p.Log("Fail to stream %s: %v", key, err)
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+	view, err := v.decode()
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 	//使用proto编码Http响应
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice(), Version: v.v})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeBody(w, req, group, body)
+}
+
+//写入响应,对方支持Group的压缩算法的时候压缩传输
+func writeBody(w http.ResponseWriter, req *http.Request, group *Group, body []byte) {
+	if c := group.opts.codec; c != nil && len(body) >= group.opts.compressThreshold && accepts(req.Header.Get("Accept-Encoding"), c.Name()) {
+		if b, err := c.Compress(body); err == nil && len(b) < len(body) {
+			body = b
+			w.Header().Set("Content-Encoding", c.Name())
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+//处理其他节点转发过来的写入请求
+func (p *HTTPPool) serveSet(w http.ResponseWriter, req *http.Request, group *Group, key string) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, NewError(CodeBadRequest, "reading request body: %v", err))
+		return
+	}
+	in := &pb.SetRequest{}
+	if err = proto.Unmarshal(b, in); err != nil {
+		writeError(w, NewError(CodeBadRequest, "decoding request body: %v", err))
+		return
+	}
+	in.Key = key
+	var version uint64
+	if err = group.checkKey(key); err == nil {
+		//直接作为key所属的节点写入,不再转发,避免节点列表不一致的时候来回转发
+		version, err = group.setLocally(req.Context(), in)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	body, err := proto.Marshal(&pb.Response{Version: version})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+//处理其他节点广播的BumpNamespace
+func (p *HTTPPool) serveNamespace(w http.ResponseWriter, req *http.Request, groupName string) {
+	group := p.registry.GetGroup(groupName)
+	if group == nil {
+		writeError(w, NewError(CodeBadRequest, "no such group: %s", groupName))
+		return
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, NewError(CodeBadRequest, "reading request body: %v", err))
+		return
+	}
+	in := &pb.Request{}
+	if err = proto.Unmarshal(b, in); err != nil || in.Generation == 0 || !validNamespace(in.Namespace) {
+		writeError(w, NewError(CodeBadRequest, "invalid namespace request"))
+		return
+	}
+	group.ns.bump(in.Namespace, in.Generation)
+}
+
+//等待seq之后的失效通知,超时的时候返回空的响应
+func (p *HTTPPool) servePoll(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	seq, _ := strconv.ParseUint(q.Get("since"), 10, 64)
+	epoch, _ := strconv.ParseUint(q.Get("epoch"), 10, 64)
+	timer := time.NewTimer(p.pollTimeout)
+	defer timer.Stop()
+	out, wait := p.log.since(seq, epoch)
+	for wait != nil {
+		select {
+		case <-wait:
+			out, wait = p.log.since(seq, epoch)
+		case <-timer.C:
+			wait = nil
+		case <-req.Context().Done():
+			return
+		case <-p.closed:
+			wait = nil
+		}
+	}
+	body, err := proto.Marshal(out)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Write(body)
 }
 
+//导出Group中属于请求节点的数据,新加入的节点通过它从原来的节点迁移数据
+//请求节点在query中带上自己的地址owner和它看到的所有节点peer,按照它的一致性Hash过滤,只返回属于它的key
+//所有节点需要使用相同的replicas和hash函数,没有owner的时候导出所有数据
+func (p *HTTPPool) serveDump(w http.ResponseWriter, req *http.Request, groupName string) {
+	group := p.registry.GetGroup(groupName)
+	if group == nil {
+		writeError(w, NewError(CodeBadRequest, "no such group: %s", groupName))
+		return
+	}
+	var keep func(key string) bool
+	if owner := req.URL.Query().Get("owner"); owner != "" {
+		peers := req.URL.Query()["peer"]
+		if len(peers) == 0 {
+			writeError(w, NewError(CodeBadRequest, "dump for %s without peers", owner))
+			return
+		}
+		ring := consistenthash.New(p.replicas, p.hashFn)
+		ring.Add(peers...)
+		keep = func(key string) bool {
+			return ring.Get(key) == owner
+		}
+	}
+	body, err := proto.Marshal(group.dump(keep))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeBody(w, req, group, body)
+}
+
+//将错误类型和错误信息编码到Response中,并设置对应的http状态码
+func writeError(w http.ResponseWriter, err error) {
+	code := Code(err)
+	body, _ := proto.Marshal(&pb.Response{Code: pb.Code(code), Error: err.Error()})
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(code.status())
+	w.Write(body)
+}
+
 //设置多节点
 func (p *HTTPPool) Set(peers ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.peers = consistenthash.New(defaultReplicas, nil)
+	p.peers = consistenthash.New(p.replicas, p.hashFn)
 	p.peers.Add(peers...)
 	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	client := &http.Client{Transport: p.transport, Timeout: p.timeout}
 	for _, peer := range peers {
 		//peer就是节点地址
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath}
+		p.httpGetters[peer] = &httpGetter{addr: peer, baseURL: peer + p.basePath, client: client}
+	}
+	p.syncPollers()
+}
+
+//发布失效通知,先通知当前节点的订阅者,其他节点通过长轮询获取
+func (p *HTTPPool) Publish(in *pb.Invalidation) {
+	p.log.append(in)
+	p.deliver(in)
+}
+
+//订阅所有节点发布的失效通知,有订阅者的时候才会轮询其他节点
+func (p *HTTPPool) Subscribe(fn func(in *pb.Invalidation)) (cancel func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := p.nextSub
+	p.nextSub++
+	p.subs[id] = fn
+	p.syncPollers()
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.subs, id)
+		p.syncPollers()
+	}
+}
+
+//停止轮询其他节点,结束正在等待的长轮询请求
+func (p *HTTPPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.closed:
+		return
+	default:
+	}
+	close(p.closed)
+	p.syncPollers()
+}
+
+func (p *HTTPPool) deliver(in *pb.Invalidation) {
+	p.mu.Lock()
+	subs := make([]func(in *pb.Invalidation), 0, len(p.subs))
+	for _, fn := range p.subs {
+		subs = append(subs, fn)
+	}
+	p.mu.Unlock()
+	for _, fn := range subs {
+		fn(in)
+	}
+}
+
+//根据节点列表和订阅者启动或者停止轮询,在持有锁的时候调用
+func (p *HTTPPool) syncPollers() {
+	active := len(p.subs) > 0
+	select {
+	case <-p.closed:
+		active = false
+	default:
+	}
+	for addr, cancel := range p.pollers {
+		if _, ok := p.httpGetters[addr]; !ok || !active {
+			cancel()
+			delete(p.pollers, addr)
+		}
+	}
+	if !active {
+		return
+	}
+	for addr, getter := range p.httpGetters {
+		if _, ok := p.pollers[addr]; ok || addr == p.self {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		p.pollers[addr] = cancel
+		go p.poll(ctx, getter)
+	}
+}
+
+//持续长轮询一个节点的失效通知,通知丢失的时候删除所有副本
+func (p *HTTPPool) poll(ctx context.Context, getter *httpGetter) {
+	var seq, epoch uint64
+	client := &http.Client{Transport: p.transport}
+	for ctx.Err() == nil {
+		out, err := getter.poll(ctx, client, seq, epoch)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.Log("Fail to poll %s: %v", getter.addr, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollRetryInterval):
+			}
+			continue
+		}
+		if out.Missed {
+			p.deliver(&pb.Invalidation{})
+		}
+		for _, in := range out.Items {
+			p.deliver(in)
+		}
+		seq, epoch = out.Seq, out.Epoch
 	}
 }
 
@@ -96,43 +427,322 @@ func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	return nil, false
 }
 
+//从其他节点拉取现在属于当前节点的数据,用于新节点加入集群之后,在接收请求之前预热
+//拉取失败的节点会跳过,返回写入的数量和最后一个错误
+func (p *HTTPPool) WarmUpFromPeers(group *Group) (int, error) {
+	p.mu.Lock()
+	getters := make([]*httpGetter, 0, len(p.httpGetters))
+	peers := make([]string, 0, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		peers = append(peers, peer)
+		if peer != p.self {
+			getters = append(getters, getter)
+		}
+	}
+	p.mu.Unlock()
+	var (
+		filled  int
+		lastErr error
+	)
+	for _, getter := range getters {
+		//由远程节点按照当前节点的一致性Hash过滤,只传输属于当前节点的数据
+		entries, err := getter.dump(group.name, p.self, peers)
+		if err != nil {
+			lastErr = fmt.Errorf("dump from %s: %v", getter.addr, err)
+			p.Log("Fail to warm up %s: %v", group.name, lastErr)
+			continue
+		}
+		for _, e := range entries.Entries {
+			if group.fill(e) {
+				filled++
+			}
+		}
+	}
+	return filled, lastErr
+}
+
+//返回除了自己之外的所有节点
+func (p *HTTPPool) Peers() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]PeerGetter, 0, len(p.httpGetters))
+	for addr, getter := range p.httpGetters {
+		if addr != p.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
 //检查接口
-var _ PeerPicker = (*HTTPPool)(nil)
+var (
+	_ PeerPicker = (*HTTPPool)(nil)
+	_ PeerLister = (*HTTPPool)(nil)
+	_ Broker     = (*HTTPPool)(nil)
+)
 
 //http客户端,用于向远程节点请求数据
 //其实可以直接理解为存远程节点的地址的结构 eg. localhost:8002/defaultPath
 type httpGetter struct {
+	addr    string //远程节点的地址 eg. http://localhost:8002
 	baseURL string
+	client  *http.Client
+}
+
+//返回远程节点的地址,方便在日志和测试中识别节点
+func (h *httpGetter) String() string {
+	return h.addr
 }
 
 //通过节点地址和groupName以及key构成的地址请求数据,通过proto解码数据
 func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
+	res, err := h.get(in, "")
+	if err != nil {
+		return err
+	}
+	body, err := readBody(res)
+	if err != nil {
+		return err
+	}
+	//解码proto并将结果存到out中
+	if err = proto.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+//流式请求数据,远程节点不支持流式响应的时候退化成读取整个Response
+func (h *httpGetter) GetStream(in *pb.Request) (io.ReadCloser, error) {
+	res, err := h.get(in, streamContentType)
+	if err != nil {
+		return nil, err
+	}
+	if res.Header.Get("Content-Type") == streamContentType {
+		return &versionedBody{res.Body, parseETag(res.Header.Get("ETag"))}, nil
+	}
+	body, err := readBody(res)
+	if err != nil {
+		return nil, err
+	}
+	out := &pb.Response{}
+	if err = proto.Unmarshal(body, out); err != nil {
+		return nil, fmt.Errorf("decoding response body: %v", err)
+	}
+	return &versionedBody{ioutil.NopCloser(bytes.NewReader(out.Value)), out.Version}, nil
+}
+
+//带版本号的流式响应
+type versionedBody struct {
+	io.ReadCloser
+	v uint64
+}
+
+func (b *versionedBody) Version() uint64 {
+	return b.v
+}
+
+//版本号编码成ETag
+func etag(v uint64) string {
+	return strconv.Quote(strconv.FormatUint(v, 10))
+}
+
+//解析ETag中的版本号,格式不正确的时候返回0
+func parseETag(s string) uint64 {
+	s, err := strconv.Unquote(strings.TrimPrefix(s, "W/"))
+	if err != nil {
+		return 0
+	}
+	v, _ := strconv.ParseUint(s, 10, 64)
+	return v
+}
+
+func (h *httpGetter) url(in *pb.Request) string {
 	u := fmt.Sprintf(
 		"%v%v/%v",
 		h.baseURL,
 		url.QueryEscape(in.GetGroup()),
 		url.QueryEscape(in.GetKey()),
 	)
-	//通过http请求远程节点的数据
-	res, err := http.Get(u)
+	if in.Generation != 0 {
+		u += "?" + url.Values{
+			"namespace":  {in.Namespace},
+			"generation": {strconv.FormatUint(in.Generation, 10)},
+		}.Encode()
+	}
+	return u
+}
+
+//长轮询远程节点seq之后的失效通知,client不设置超时
+func (h *httpGetter) poll(ctx context.Context, client *http.Client, seq, epoch uint64) (*pb.Invalidations, error) {
+	u := h.baseURL + pollPath + "/?" + url.Values{
+		"since": {strconv.FormatUint(seq, 10)},
+		"epoch": {strconv.FormatUint(epoch, 10)},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := checkResponse(client.Do(req))
+	if err != nil {
+		return nil, err
+	}
+	body, err := readBody(res)
+	if err != nil {
+		return nil, err
+	}
+	out := &pb.Invalidations{}
+	if err = proto.Unmarshal(body, out); err != nil {
+		return nil, fmt.Errorf("decoding response body: %v", err)
+	}
+	return out, nil
+}
+
+//通知远程节点更新命名空间的代数
+func (h *httpGetter) BumpNamespace(ctx context.Context, in *pb.Request) error {
+	body, err := proto.Marshal(in)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
+	res, err := h.exec(ctx, http.MethodPost, h.baseURL+namespacePath+"/"+url.QueryEscape(in.Group), body)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+//把写入请求转发给key所属的节点,新的版本号保存在out中
+func (h *httpGetter) Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	res, err := h.exec(ctx, http.MethodPut, h.url(&pb.Request{Group: in.Group, Key: in.Key}), body)
+	if err != nil {
+		return err
+	}
+	if body, err = readBody(res); err != nil {
+		return err
+	}
+	if err = proto.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+//删除远程节点hotCache中的副本
+func (h *httpGetter) Invalidate(ctx context.Context, in *pb.Request) error {
+	res, err := h.exec(ctx, http.MethodDelete, h.url(in), nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+//发送带body的请求,成功的时候由调用者读取并关闭body
+func (h *httpGetter) exec(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return h.send(req, "")
+}
+
+//导出远程节点中Group属于owner的数据,peers是owner看到的所有节点
+func (h *httpGetter) dump(group, owner string, peers []string) (*pb.Entries, error) {
+	query := url.Values{"owner": {owner}, "peer": peers}
+	res, err := h.do(h.baseURL+dumpPath+"/"+url.QueryEscape(group)+"?"+query.Encode(), "")
+	if err != nil {
+		return nil, err
+	}
+	body, err := readBody(res)
+	if err != nil {
+		return nil, err
+	}
+	out := &pb.Entries{}
+	if err = proto.Unmarshal(body, out); err != nil {
+		return nil, fmt.Errorf("decoding response body: %v", err)
+	}
+	return out, nil
+}
+
+//GET请求远程节点,成功的时候由调用者读取并关闭body
+func (h *httpGetter) do(u string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return h.send(req, accept)
+}
+
+//请求key对应的数据,带上已有的版本号,没有变化的时候返回ErrNotModified
+func (h *httpGetter) get(in *pb.Request, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, h.url(in), nil)
+	if err != nil {
+		return nil, err
+	}
+	if in.Version != 0 {
+		req.Header.Set("If-None-Match", etag(in.Version))
+	}
+	return h.send(req, accept)
+}
+
+//发送请求,远程节点返回错误的时候返回对应类型的错误
+func (h *httpGetter) send(req *http.Request, accept string) (*http.Response, error) {
+	//自己设置Accept-Encoding之后Transport不会自动解压,由readBody根据Content-Encoding解压
+	req.Header.Set("Accept-Encoding", acceptEncoding())
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	//通过http请求远程节点的数据
+	return checkResponse(h.client.Do(req))
+}
+
+//远程节点返回错误的时候从Response中解码错误类型
+func checkResponse(res *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		if Code(err) == CodeTimeout {
+			return nil, NewError(CodeTimeout, "%v", err)
+		}
+		return nil, err
+	}
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+		defer res.Body.Close()
+		//远程节点返回了错误类型
+		out := &pb.Response{}
+		if b, err := ioutil.ReadAll(res.Body); err == nil && proto.Unmarshal(b, out) == nil && out.Code != pb.Code_OK {
+			return nil, &Error{Code: ErrorCode(out.Code), Msg: out.Error}
+		}
+		return nil, NewError(codeOfStatus(res.StatusCode), "server returned: %v", res.Status)
 	}
+	return res, nil
+}
+
+//读取并关闭body,根据Content-Encoding解压
+func readBody(res *http.Response) ([]byte, error) {
+	defer res.Body.Close()
 	//转换成[]byte
-	bytes, err := ioutil.ReadAll(res.Body)
+	b, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+		return nil, fmt.Errorf("reading response body: %v", err)
 	}
-	//解码proto并将结果存到out中
-	if err != proto.Unmarshal(bytes, out) {
-		return fmt.Errorf("decoding response body : %v", err)
+	if name := res.Header.Get("Content-Encoding"); name != "" {
+		c, ok := codecOf(name)
+		if !ok {
+			return nil, fmt.Errorf("unsupported content encoding %q", name)
+		}
+		if b, err = c.Decompress(b); err != nil {
+			return nil, fmt.Errorf("decoding response body: %v", err)
+		}
 	}
-	return nil
+	return b, nil
 }
 
 //接口实现判断
-var _ PeerGetter = (*httpGetter)(nil)
+var (
+	_ PeerGetter     = (*httpGetter)(nil)
+	_ PeerStreamer   = (*httpGetter)(nil)
+	_ PeerSetter     = (*httpGetter)(nil)
+	_ PeerNamespacer = (*httpGetter)(nil)
+)
@@ -2,13 +2,23 @@ package lru
 
 import (
 	"container/list"
+	"unsafe"
 )
 
+//EntryOverhead 估算的每条数据在Cache内部的额外内存: 链表节点,entry结构体和map中的槽位(按照约一半的装载率估算)
+//不包括Value本身的结构体(比如装箱到接口中的ByteView),使用者需要自己加上
+const EntryOverhead = int64(unsafe.Sizeof(list.Element{})+unsafe.Sizeof(entry{})) + mapSlotOverhead
+
+//map中每个槽位的大小: string类型的key + *list.Element + 控制字节
+const mapSlotOverhead = 2 * int64(unsafe.Sizeof("")+unsafe.Sizeof(&list.Element{})+1)
+
 type Cache struct {
 	maxBytes  int64                         //最大可用内存,0代表无限制
 	nbytes    int64                         //已使用内存
+	overhead  int64                         //每条数据额外计算的内存
 	ll        *list.List                    //双向链表
 	cache     map[string]*list.Element      //key和list节点映射
+	fifo      bool                          //FIFO模式,Get的时候不调整节点位置
 	OnEvicted func(key string, value Value) //回调函数
 }
 
@@ -33,9 +43,18 @@ func New(maxBytes int64, onEvicted func(key string, value Value)) *Cache {
 	}
 }
 
+//NewFIFO 和New一样,但是按照写入顺序淘汰,访问不会改变淘汰顺序
+func NewFIFO(maxBytes int64, onEvicted func(key string, value Value)) *Cache {
+	c := New(maxBytes, onEvicted)
+	c.fifo = true
+	return c
+}
+
 func (c *Cache) Get(key string) (value Value, ok bool) {
 	if element, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(element)    //移动到队头,(go的源码看起来真舒服)
+		if !c.fifo {
+			c.ll.MoveToFront(element) //移动到队头,(go的源码看起来真舒服)
+		}
 		kv := element.Value.(*entry) //强转成entry
 		return kv.value, true
 	}
@@ -46,22 +65,35 @@ func (c *Cache) Get(key string) (value Value, ok bool) {
 func (c *Cache) RemoveOldest() {
 	tail := c.ll.Back()
 	if tail != nil {
-		c.ll.Remove(tail)
-		kv := tail.Value.(*entry)
-		delete(c.cache, kv.key)
-		//key是string val是Value接口实现类
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.OnEvicted != nil {
-			//逐出key的回调函数
-			c.OnEvicted(kv.key, kv.value)
-		}
+		c.removeElement(tail)
+	}
+}
+
+//删除指定的key
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	c.ll.Remove(ele)
+	kv := ele.Value.(*entry)
+	delete(c.cache, kv.key)
+	//key是string val是Value接口实现类
+	c.nbytes -= c.size(kv.key, kv.value)
+	if c.OnEvicted != nil {
+		//逐出key的回调函数
+		c.OnEvicted(kv.key, kv.value)
 	}
 }
 
 //新增or修改
 func (c *Cache) Put(key string, value Value) {
 	if ele, ok := c.cache[key]; ok { //修改
-		c.ll.MoveToFront(ele)
+		if !c.fifo {
+			c.ll.MoveToFront(ele)
+		}
 		kv := ele.Value.(*entry)
 		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
 		kv.value = value
@@ -69,7 +101,7 @@ func (c *Cache) Put(key string, value Value) {
 		//新增的放到头部
 		ele := c.ll.PushFront(&entry{key, value})
 		c.cache[key] = ele
-		c.nbytes += int64(len(key)) + int64(value.Len())
+		c.nbytes += c.size(key, value)
 	}
 	//内存不足
 	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
@@ -77,6 +109,43 @@ func (c *Cache) Put(key string, value Value) {
 	}
 }
 
+//调整最大可用内存,变小的时候会按照淘汰顺序逐出数据
+func (c *Cache) SetMaxBytes(maxBytes int64) {
+	c.maxBytes = maxBytes
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+		c.RemoveOldest()
+	}
+}
+
+//设置每条数据额外计算的内存,已有的数据按照新的值重新计算,超出maxBytes的时候逐出数据
+func (c *Cache) SetEntryOverhead(overhead int64) {
+	c.nbytes += (overhead - c.overhead) * int64(c.ll.Len())
+	c.overhead = overhead
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+		c.RemoveOldest()
+	}
+}
+
+//一条数据占用的内存
+func (c *Cache) size(key string, value Value) int64 {
+	return int64(len(key)) + int64(value.Len()) + c.overhead
+}
+
+//已使用的内存
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
+
+//从最旧到最新遍历所有数据,fn返回false的时候停止遍历,遍历过程中不能修改Cache
+func (c *Cache) Range(fn func(key string, value Value) bool) {
+	for ele := c.ll.Back(); ele != nil; ele = ele.Prev() {
+		kv := ele.Value.(*entry)
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
@@ -54,3 +54,74 @@ func TestOnEvicted(t *testing.T) {
 		t.Fatalf("Call onevicted failed expect:%s , keys: %s", expect, keys)
 	}
 }
+
+func TestFIFO(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "key3"
+	v1, v2, v3 := "value1", "value2", "value3"
+	cap := len(k1 + k2 + v1 + v2)
+	fifo := NewFIFO(int64(cap), nil)
+	fifo.Put(k1, String(v1))
+	fifo.Put(k2, String(v2))
+	fifo.Get(k1)             //FIFO模式下访问不影响淘汰顺序
+	fifo.Put(k3, String(v3)) //插入k3的时候k1依然会被移除
+	if _, ok := fifo.Get(k1); ok || fifo.Len() != 2 {
+		t.Fatalf("fifo remove key1 fail")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Put("key1", String("value1"))
+	lru.Remove("key1")
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 0 || lru.nbytes != 0 {
+		t.Fatalf("remove key1 fail")
+	}
+}
+
+func TestSetMaxBytes(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Put("key1", String("value1"))
+	lru.Put("key2", String("value2"))
+	lru.Put("key3", String("value3"))
+	lru.Get("key1")
+	lru.SetMaxBytes(20) //只能放下两个,key2最久未使用
+	if _, ok := lru.Get("key2"); ok || lru.Len() != 2 {
+		t.Fatalf("shrink should remove key2")
+	}
+	lru.SetMaxBytes(40)
+	lru.Put("key4", String("value4"))
+	if lru.Len() != 3 {
+		t.Fatalf("grow should keep all keys")
+	}
+}
+
+func TestRange(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Put("key1", String("value1"))
+	lru.Put("key2", String("value2"))
+	lru.Put("key3", String("value3"))
+	lru.Get("key1")
+	var keys []string
+	lru.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	if !reflect.DeepEqual(keys, []string{"key2", "key3"}) {
+		t.Fatalf("range from oldest failed, got %v", keys)
+	}
+}
+
+func TestEntryOverhead(t *testing.T) {
+	lru := New(int64(40), nil)
+	lru.Put("key1", String("value1"))
+	lru.Put("key2", String("value2"))
+	//每条数据额外计算11byte,21+21超出40之后逐出key1
+	lru.SetEntryOverhead(11)
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 1 || lru.Bytes() != 21 {
+		t.Fatalf("overhead should be counted, used %d bytes", lru.Bytes())
+	}
+	lru.Put("k3", String("v3"))
+	if lru.Bytes() != 36 {
+		t.Fatalf("overhead should be counted for new entries")
+	}
+}
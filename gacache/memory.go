@@ -0,0 +1,47 @@
+package gacache
+
+import (
+	"gacache/lru"
+	"runtime"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+//EntryOverhead 估算的每条数据的额外内存: lru内部的结构加上装箱到lru.Value接口中的ByteView
+//没有计算内存分配器按照size class向上取整的部分,数据很小的时候实际值会更大,可以用MeasureEntryOverhead测量
+const EntryOverhead = lru.EntryOverhead + int64(unsafe.Sizeof(ByteView{}))
+
+//测量时写入的数据条数
+const measureEntries = 10000
+
+//MeasureEntryOverhead 实际测量每条数据的额外内存: 写入keyLen和valueLen大小的数据,对比写入前后存活的堆内存
+//结果受当前运行时和数据大小影响,会触发两次GC,适合在启动的时候调用一次
+func MeasureEntryOverhead(keyLen, valueLen int) int64 {
+	c := &cache{now: time.Now}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	var size int64
+	for i := 0; i < measureEntries; i++ {
+		key := strconv.Itoa(i)
+		if n := keyLen - len(key); n > 0 {
+			key = string(make([]byte, n)) + key
+		}
+		c.put(key, ByteView{b: make([]byte, valueLen)})
+		size += int64(len(key) + valueLen)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(c)
+	overhead := (int64(after.HeapAlloc) - int64(before.HeapAlloc) - size) / measureEntries
+	if overhead < 0 {
+		return 0
+	}
+	return overhead
+}
+
+//mainCache和hotCache已使用的内存,包括每条数据额外计算的内存
+func (g *Group) CacheBytes() int64 {
+	return g.mainCache.bytes() + g.hotCache.bytes()
+}
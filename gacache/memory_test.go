@@ -0,0 +1,56 @@
+package gacache
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestEntryOverhead(t *testing.T) {
+	//测量值受运行时和GC影响,只检查堆内存的增长超过了key和value本身的大小
+	if m := MeasureEntryOverhead(16, 100); m <= 0 || EntryOverhead <= 0 {
+		t.Fatalf("overhead should be counted, estimated %d, measured %d", EntryOverhead, m)
+	}
+	g := NewRegistry().NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("630"), nil
+	}), WithEntryOverhead(100), WithHotCacheRatio(0))
+	for i := 0; i < 100; i++ {
+		g.Get(strconv.Itoa(i))
+	}
+	//每条数据占用 key + 3 + 100,2KB最多只能放下19条
+	if n := g.mainCache.lru.Len(); n != 19 || g.CacheBytes() > 2<<10 {
+		t.Fatalf("overhead should be counted, %d entries use %d bytes", n, g.CacheBytes())
+	}
+	if _, err := NewRegistry().NewGroupWithOptions("scores", 2<<10, g.getter, WithEntryOverhead(-1)); err == nil {
+		t.Fatalf("negative entry overhead should fail")
+	}
+}
+
+//写满cache之后实际增长的堆内存和cacheBytes的比例,开启EntryOverhead之后应该不超过1
+func BenchmarkMemoryBound(b *testing.B) {
+	for _, bench := range []struct {
+		name     string
+		overhead int64
+	}{{"none", 0}, {"estimated", EntryOverhead}} {
+		b.Run(bench.name, func(b *testing.B) {
+			const cacheBytes = 4 << 20
+			var ratio float64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				g := NewRegistry().NewGroup("scores", cacheBytes, GetterFunc(func(key string) ([]byte, error) {
+					return []byte("630"), nil
+				}), WithEntryOverhead(bench.overhead), WithHotCacheRatio(0))
+				for j := 0; g.mainCache.lru == nil || g.CacheBytes() < cacheBytes*9/10; j++ {
+					g.Get(strconv.Itoa(j))
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				ratio = float64(after.HeapAlloc-before.HeapAlloc) / cacheBytes
+				runtime.KeepAlive(g)
+			}
+			b.ReportMetric(ratio, "heap/cacheBytes")
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package gacache
+
+import (
+	"context"
+	pb "gacache/gacachepb"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//代数和key之间的分隔符
+const namespaceSep = "\x01"
+
+//命名空间(key的前缀)的代数,""代表整个Group
+//代数加入mainCache和hotCache中存储的key,BumpNamespace之后旧的数据无法再访问,最终被LRU淘汰
+type namespaces struct {
+	active  int32 //是否有命名空间,没有的时候不需要加锁
+	mu      sync.RWMutex
+	gens    map[string]uint64
+	lengths []int //所有前缀的长度,从小到大
+}
+
+//key所属的命名空间和代数,多个前缀匹配的时候取代数最大的
+func (n *namespaces) lookup(key string) (prefix string, gen uint64) {
+	if n == nil || atomic.LoadInt32(&n.active) == 0 {
+		return "", 0
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, l := range n.lengths {
+		if l > len(key) {
+			break
+		}
+		if g, ok := n.gens[key[:l]]; ok && g > gen {
+			prefix, gen = key[:l], g
+		}
+	}
+	return
+}
+
+//key在cache中实际存储的key,没有命名空间的时候就是key本身
+//key本身包含分隔符的时候即使代数是0也加上代数,最后一个分隔符之后的一定是代数,解析的时候不会出错
+func (n *namespaces) key(key string) string {
+	if _, gen := n.lookup(key); gen != 0 || strings.Contains(key, namespaceSep) {
+		return key + namespaceSep + strconv.FormatUint(gen, 36)
+	}
+	return key
+}
+
+//更新前缀的代数,gen为0的时候生成一个比所有已有代数都大的新代数,否则只在gen更大的时候更新
+//返回前缀当前的代数
+func (n *namespaces) bump(prefix string, gen uint64) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if gen == 0 {
+		//使用时间戳,不同节点各自生成的代数也大致有序
+		gen = uint64(time.Now().UnixNano())
+		for _, g := range n.gens {
+			if g >= gen {
+				gen = g + 1
+			}
+		}
+	}
+	old, ok := n.gens[prefix]
+	if gen <= old {
+		return old
+	}
+	if n.gens == nil {
+		n.gens = map[string]uint64{}
+	}
+	n.gens[prefix] = gen
+	if !ok {
+		i := sort.SearchInts(n.lengths, len(prefix))
+		if i == len(n.lengths) || n.lengths[i] != len(prefix) {
+			n.lengths = append(n.lengths, 0)
+			copy(n.lengths[i+1:], n.lengths[i:])
+			n.lengths[i] = len(prefix)
+		}
+	}
+	atomic.StoreInt32(&n.active, 1)
+	return gen
+}
+
+//命名空间不能包含chunk的分隔符
+func validNamespace(prefix string) bool {
+	return !strings.Contains(prefix, "\x00")
+}
+
+//从cache中存储的key解析出原来的key和代数
+func splitNamespace(key string) (string, uint64) {
+	i := strings.LastIndex(key, namespaceSep)
+	if i < 0 {
+		return key, 0
+	}
+	gen, _ := strconv.ParseUint(key[i+len(namespaceSep):], 36, 64)
+	return key[:i], gen
+}
+
+//使以prefix开头的所有key的缓存失效,prefix为""的时候使整个Group失效,同时通知所有节点
+//旧的数据不会立即删除,而是无法再访问,之后被淘汰,返回最后一个通知失败的错误
+func (g *Group) BumpNamespace(ctx context.Context, prefix string) error {
+	if !validNamespace(prefix) {
+		return NewError(CodeBadRequest, "namespace contains invalid character")
+	}
+	gen := g.ns.bump(prefix, 0)
+	l, ok := g.peers.(PeerLister)
+	if !ok {
+		return nil
+	}
+	var lastErr error
+	in := &pb.Request{Group: g.name, Namespace: prefix, Generation: gen}
+	for _, peer := range l.Peers() {
+		if b, ok := peer.(PeerNamespacer); ok {
+			if err := b.BumpNamespace(ctx, in); err != nil {
+				lastErr = err
+				log.Println("[Gacache] Fail to bump namespace", prefix, "on", PeerName(peer), err)
+			}
+		}
+	}
+	return lastErr
+}
+
+//key当前的代数,从来没有BumpNamespace的时候是0
+func (g *Group) Generation(key string) uint64 {
+	_, gen := g.ns.lookup(key)
+	return gen
+}
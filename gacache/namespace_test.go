@@ -0,0 +1,110 @@
+package gacache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNamespaces(t *testing.T) {
+	var n namespaces
+	if n.key("tenant1:Tom") != "tenant1:Tom" {
+		t.Fatalf("key should not change without namespaces")
+	}
+	g1 := n.bump("tenant1:", 0)
+	g2 := n.bump("", 0)
+	if g2 <= g1 {
+		t.Fatalf("new generation should be greater than existing ones")
+	}
+	//多个前缀匹配的时候取代数最大的
+	if prefix, gen := n.lookup("tenant1:Tom"); prefix != "" || gen != g2 {
+		t.Fatalf("expect generation %d, but got %s %d", g2, prefix, gen)
+	}
+	g3 := n.bump("tenant1:", 0)
+	if prefix, gen := n.lookup("tenant1:Tom"); prefix != "tenant1:" || gen != g3 {
+		t.Fatalf("expect generation %d, but got %s %d", g3, prefix, gen)
+	}
+	//旧的代数不会覆盖新的
+	if n.bump("tenant1:", g1) != g3 {
+		t.Fatalf("older generation should be ignored")
+	}
+	if key, gen := splitNamespace(n.key("tenant1:Tom")); key != "tenant1:Tom" || gen != g3 {
+		t.Fatalf("split namespace fail: %s %d", key, gen)
+	}
+	//key中包含分隔符的时候不会和带代数的key混淆
+	var m namespaces
+	key := "Tom" + namespaceSep + "1"
+	if m.key(key) == key || m.key(key) == m.key("Tom") {
+		t.Fatalf("key with separator should be escaped")
+	}
+	if k, gen := splitNamespace(m.key(key)); k != key || gen != 0 {
+		t.Fatalf("split namespace fail: %q %d", k, gen)
+	}
+	if k, gen := splitNamespace(n.key("tenant1:" + key)); k != "tenant1:"+key || gen != g3 {
+		t.Fatalf("split namespace fail: %q %d", k, gen)
+	}
+}
+
+func TestBumpNamespace(t *testing.T) {
+	g := NewRegistry().NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("630"), nil
+	}), WithChunkSize(2))
+	var evicted []string
+	g.Subscribe(func(e Event) {
+		if e.Type == EventEvict {
+			evicted = append(evicted, e.Key)
+		}
+	})
+	keys := []string{"tenant1:Tom", "tenant1:Jack", "tenant2:Tom"}
+	get := func() {
+		for _, key := range keys {
+			if v, err := g.Get(key); err != nil || v.String() != "630" {
+				t.Fatalf("get %s fail: %v", key, err)
+			}
+		}
+	}
+	get()
+	ctx := context.Background()
+	if err := g.BumpNamespace(ctx, "tenant1:"); err != nil {
+		t.Fatal(err)
+	}
+	get()
+	//只有tenant1的数据需要重新加载
+	if g.Stats.LocalLoads.Get() != 5 {
+		t.Fatalf("tenant1 should be reloaded, loads %d", g.Stats.LocalLoads.Get())
+	}
+	g.BumpNamespace(ctx, "")
+	get()
+	if g.Stats.LocalLoads.Get() != 8 || g.Generation("tenant2:Tom") == 0 {
+		t.Fatalf("whole group should be reloaded, loads %d", g.Stats.LocalLoads.Get())
+	}
+	//旧的数据不会被导出
+	if entries := g.dump(nil).Entries; len(entries) != len(keys) {
+		t.Fatalf("expect %d entries, but got %d", len(keys), len(entries))
+	}
+	//淘汰事件中的key不带代数
+	g.SetCacheBytes(2)
+	for _, key := range evicted {
+		if key != "tenant1:Tom" && key != "tenant1:Jack" && key != "tenant2:Tom" {
+			t.Fatalf("unexpected evicted key %q", key)
+		}
+	}
+	if len(evicted) == 0 {
+		t.Fatalf("entries should be evicted")
+	}
+	//key中可以包含分隔符
+	g.SetCacheBytes(2 << 10)
+	key := "tenant1:Tom" + namespaceSep + "1"
+	if v, err := g.Get(key); err != nil || v.String() != "630" {
+		t.Fatalf("key with separator should be allowed: %v", err)
+	}
+	found := false
+	for _, e := range g.dump(nil).Entries {
+		found = found || e.Key == key
+	}
+	if !found {
+		t.Fatalf("key with separator should be dumped")
+	}
+	if err := g.BumpNamespace(ctx, "\x00"); err == nil {
+		t.Fatalf("namespace with chunk separator should fail")
+	}
+}
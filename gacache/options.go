@@ -0,0 +1,390 @@
+package gacache
+
+import (
+	"errors"
+	"fmt"
+	"gacache/consistenthash"
+	"gacache/lru"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//默认hotCache占总内存的比例
+const defaultHotCacheRatio = 1.0 / 8
+
+//缓存淘汰策略
+type EvictionPolicy int
+
+const (
+	LRU  EvictionPolicy = iota //最近最少使用
+	FIFO                       //先进先出
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case LRU:
+		return "lru"
+	case FIFO:
+		return "fifo"
+	}
+	return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+}
+
+//根据淘汰策略创建底层的cache
+func (p EvictionPolicy) newCache(maxBytes int64, onEvicted func(key string, value lru.Value)) *lru.Cache {
+	if p == FIFO {
+		return lru.NewFIFO(maxBytes, onEvicted)
+	}
+	return lru.New(maxBytes, onEvicted)
+}
+
+//cache的存储引擎
+type Storage int
+
+const (
+	HeapStorage  Storage = iota //每条数据是一个独立的ByteView,由lru.Cache管理
+	ArenaStorage                //数据编码之后存放在预先分配的大块内存中,减少GC扫描,淘汰顺序近似LRU
+)
+
+func (s Storage) String() string {
+	switch s {
+	case HeapStorage:
+		return "heap"
+	case ArenaStorage:
+		return "arena"
+	}
+	return fmt.Sprintf("Storage(%d)", int(s))
+}
+
+//Group的可选配置
+type groupOptions struct {
+	hotCacheRatio float64        //hotCache占总内存的比例
+	policy        EvictionPolicy //淘汰策略
+	ttl           time.Duration  //过期时间,0代表永不过期
+	softTTL       time.Duration  //软过期时间,0代表不开启stale-while-revalidate
+	//refresh-ahead的配置,refreshWorkers为0代表不开启
+	refreshWindow    float64
+	refreshMinAccess int64
+	refreshWorkers   int
+	//从远程节点获取数据失败之后是否从本地的数据源获取
+	peerFallback func(err error) bool
+	//磁盘二级缓存的目录和大小,diskBytes为0代表不开启
+	diskDir   string
+	diskBytes int64
+	//压缩算法和阈值,codec为nil代表不压缩
+	codec             Codec
+	compressThreshold int
+	//分块存储的块大小,0代表不分块
+	chunkSize int
+	//单条数据(key+value)的最大字节数,0代表只受cache容量限制
+	maxEntryBytes int64
+	//准入策略,nil代表全部存入
+	admission Admission
+	//每条数据额外计算的内存,0代表只计算key和value的大小
+	entryOverhead int64
+	//存储引擎
+	storage Storage
+	//Set时写入数据源的回调,nil代表只更新缓存
+	setter Setter
+	//write-behind的配置,writeInterval为0代表write-through
+	writeInterval time.Duration
+	writeBatch    int
+	writeRetries  int
+	//判断过期使用的时钟
+	now func() time.Time
+}
+
+type GroupOption func(*groupOptions)
+
+//设置hotCache占总内存的比例,0代表不使用hotCache
+func WithHotCacheRatio(ratio float64) GroupOption {
+	return func(o *groupOptions) {
+		o.hotCacheRatio = ratio
+	}
+}
+
+//设置淘汰策略,默认LRU
+func WithEvictionPolicy(policy EvictionPolicy) GroupOption {
+	return func(o *groupOptions) {
+		o.policy = policy
+	}
+}
+
+//设置缓存的过期时间,默认永不过期
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(o *groupOptions) {
+		o.ttl = ttl
+	}
+}
+
+//开启stale-while-revalidate模式,数据加载softTTL之后依然返回旧值,同时在后台刷新
+//WithTTL设置的过期时间到了之后旧值不再返回,softTTL需要小于ttl
+func WithStaleWhileRevalidate(softTTL time.Duration) GroupOption {
+	return func(o *groupOptions) {
+		o.softTTL = softTTL
+	}
+}
+
+//开启refresh-ahead,剩余的过期时间小于window*ttl并且访问次数达到minAccess的key
+//会由workers个后台worker提前从数据源重新加载,需要同时设置WithTTL
+func WithRefreshAhead(window float64, minAccess int64, workers int) GroupOption {
+	return func(o *groupOptions) {
+		o.refreshWindow = window
+		o.refreshMinAccess = minAccess
+		o.refreshWorkers = workers
+	}
+}
+
+//设置从远程节点获取数据失败之后是否从本地的数据源获取
+//默认除了远程节点返回ErrNotFound之外都会从本地的数据源获取
+func WithPeerFallback(fallback func(err error) bool) GroupOption {
+	return func(o *groupOptions) {
+		o.peerFallback = fallback
+	}
+}
+
+//开启磁盘二级缓存,mainCache淘汰的数据会写入dir/<groupName>,最多占用maxBytes的磁盘空间
+//cache miss的时候先查磁盘,再去远程节点或者数据源获取
+func WithDiskCache(dir string, maxBytes int64) GroupOption {
+	return func(o *groupOptions) {
+		o.diskDir = dir
+		o.diskBytes = maxBytes
+	}
+}
+
+//开启压缩,大于等于threshold字节的数据压缩之后存储,内存按照压缩之后的大小计算
+//节点之间传输的时候如果对方支持该算法,也会压缩传输
+func WithCompression(codec Codec, threshold int) GroupOption {
+	return func(o *groupOptions) {
+		o.codec = codec
+		o.compressThreshold = threshold
+	}
+}
+
+//开启分块存储,超过size字节的value切分成size大小的chunk分别存储,节点之间流式传输
+//Getter实现了StreamGetter的时候从数据源流式读取,分块存储的value不会写入磁盘二级缓存
+func WithChunkSize(size int) GroupOption {
+	return func(o *groupOptions) {
+		o.chunkSize = size
+	}
+}
+
+//设置单条数据(key+value)的最大字节数,超过的数据依然会返回给调用者,但是不会存入缓存
+//超过mainCache或者hotCache容量的数据无论是否设置都不会存入
+func WithMaxEntryBytes(n int64) GroupOption {
+	return func(o *groupOptions) {
+		o.maxEntryBytes = n
+	}
+}
+
+//设置准入策略,从数据源或者远程节点加载的数据经过它的允许才会存入缓存,比如NewDoorkeeper
+func WithAdmission(a Admission) GroupOption {
+	return func(o *groupOptions) {
+		o.admission = a
+	}
+}
+
+//设置每条数据额外计算的内存(链表节点,map槽位,ByteView等结构体),让cacheBytes接近实际占用的内存
+//可以使用估算的EntryOverhead或者启动时通过MeasureEntryOverhead测量的值
+func WithEntryOverhead(n int64) GroupOption {
+	return func(o *groupOptions) {
+		o.entryOverhead = n
+	}
+}
+
+//设置存储引擎,ArenaStorage在启动时按照cacheBytes分配内存,cacheBytes必须大于0
+//ArenaStorage不支持refresh-ahead,压缩算法需要通过RegisterCodec注册
+func WithStorage(s Storage) GroupOption {
+	return func(o *groupOptions) {
+		o.storage = s
+	}
+}
+
+//设置Set时写入数据源的回调,默认同步写入(write-through),写入失败的时候Set返回错误并且不更新缓存
+func WithSetter(s Setter) GroupOption {
+	return func(o *groupOptions) {
+		o.setter = s
+	}
+}
+
+//开启write-behind,Set只更新缓存,数据每隔interval或者攒够batch条之后在后台写入Setter
+//写入失败的数据最多重试retries次,Group关闭(DeleteGroup)的时候写入剩下的数据,需要同时设置WithSetter
+func WithWriteBehind(interval time.Duration, batch, retries int) GroupOption {
+	return func(o *groupOptions) {
+		o.writeInterval = interval
+		o.writeBatch = batch
+		o.writeRetries = retries
+	}
+}
+
+//设置判断过期使用的时钟,默认time.Now,测试中可以让时间前进而不需要真的等待
+func WithClock(now func() time.Time) GroupOption {
+	return func(o *groupOptions) {
+		o.now = now
+	}
+}
+
+//远程节点返回key不存在的时候不再从本地数据源获取
+func defaultPeerFallback(err error) bool {
+	return !errors.Is(err, ErrNotFound)
+}
+
+func newGroupOptions(opts []GroupOption) (groupOptions, error) {
+	o := groupOptions{
+		hotCacheRatio: defaultHotCacheRatio,
+		policy:        LRU,
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.now == nil {
+		return o, fmt.Errorf("nil clock")
+	}
+	if o.peerFallback == nil {
+		o.peerFallback = defaultPeerFallback
+	}
+	if o.hotCacheRatio < 0 || o.hotCacheRatio >= 1 {
+		return o, fmt.Errorf("invalid hot cache ratio %v, should be in [0, 1)", o.hotCacheRatio)
+	}
+	if o.policy != LRU && o.policy != FIFO {
+		return o, fmt.Errorf("unknown eviction policy %v", o.policy)
+	}
+	if o.ttl < 0 {
+		return o, fmt.Errorf("invalid ttl %v", o.ttl)
+	}
+	if o.softTTL < 0 || (o.ttl > 0 && o.softTTL >= o.ttl) {
+		return o, fmt.Errorf("invalid stale-while-revalidate ttl %v, should be in [0, %v)", o.softTTL, o.ttl)
+	}
+	if o.refreshWorkers < 0 {
+		return o, fmt.Errorf("invalid refresh-ahead workers %d", o.refreshWorkers)
+	}
+	if o.refreshWorkers > 0 {
+		if o.ttl == 0 {
+			return o, fmt.Errorf("refresh-ahead requires ttl")
+		}
+		if o.refreshWindow <= 0 || o.refreshWindow >= 1 {
+			return o, fmt.Errorf("invalid refresh window %v, should be in (0, 1)", o.refreshWindow)
+		}
+		if o.refreshMinAccess < 1 {
+			return o, fmt.Errorf("invalid refresh-ahead min access %d", o.refreshMinAccess)
+		}
+	}
+	if o.diskBytes < 0 {
+		return o, fmt.Errorf("invalid disk cache bytes %d", o.diskBytes)
+	}
+	if o.diskBytes > 0 && o.diskDir == "" {
+		return o, fmt.Errorf("disk cache requires dir")
+	}
+	if o.compressThreshold < 0 {
+		return o, fmt.Errorf("invalid compress threshold %d", o.compressThreshold)
+	}
+	if o.chunkSize < 0 {
+		return o, fmt.Errorf("invalid chunk size %d", o.chunkSize)
+	}
+	if o.maxEntryBytes < 0 {
+		return o, fmt.Errorf("invalid max entry bytes %d", o.maxEntryBytes)
+	}
+	if o.entryOverhead < 0 {
+		return o, fmt.Errorf("invalid entry overhead %d", o.entryOverhead)
+	}
+	if o.writeInterval < 0 {
+		return o, fmt.Errorf("invalid write-behind interval %v", o.writeInterval)
+	}
+	if o.writeInterval > 0 {
+		if o.setter == nil {
+			return o, fmt.Errorf("write-behind requires setter")
+		}
+		if o.writeBatch < 1 || o.writeRetries < 0 {
+			return o, fmt.Errorf("invalid write-behind batch %d or retries %d", o.writeBatch, o.writeRetries)
+		}
+	}
+	switch o.storage {
+	case HeapStorage:
+	case ArenaStorage:
+		//arena中存不了访问次数的指针
+		if o.refreshWorkers > 0 {
+			return o, fmt.Errorf("refresh-ahead is not supported with arena storage")
+		}
+		if o.codec != nil {
+			if _, ok := codecOf(o.codec.Name()); !ok {
+				return o, fmt.Errorf("codec %s should be registered to use arena storage", o.codec.Name())
+			}
+		}
+	default:
+		return o, fmt.Errorf("unknown storage %v", o.storage)
+	}
+	return o, nil
+}
+
+//HTTPPool的可选配置
+type HTTPPoolOption func(*HTTPPool)
+
+//设置节点间通讯地址的前缀,必须以'/'开头和结尾
+func WithBasePath(basePath string) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.basePath = basePath
+	}
+}
+
+//设置一致性Hash的虚拟节点倍数
+func WithReplicas(replicas int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.replicas = replicas
+	}
+}
+
+//设置一致性Hash的hash函数,默认crc32
+func WithHash(fn consistenthash.Hash) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.hashFn = fn
+	}
+}
+
+//设置请求远程节点使用的Transport,默认http.DefaultTransport
+func WithTransport(transport http.RoundTripper) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.transport = transport
+	}
+}
+
+//设置请求远程节点的超时时间,超时返回ErrTimeout,默认不超时
+func WithTimeout(timeout time.Duration) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.timeout = timeout
+	}
+}
+
+//设置长轮询失效通知的最长等待时间,默认30s
+func WithPollTimeout(timeout time.Duration) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.pollTimeout = timeout
+	}
+}
+
+//设置处理请求时查找Group的Registry,默认DefaultRegistry
+func WithRegistry(registry *Registry) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.registry = registry
+	}
+}
+
+func (p *HTTPPool) validate() error {
+	if !strings.HasPrefix(p.basePath, "/") || !strings.HasSuffix(p.basePath, "/") {
+		return fmt.Errorf("invalid base path %q, should begin and end with '/'", p.basePath)
+	}
+	if p.replicas <= 0 {
+		return fmt.Errorf("invalid replicas %d, should be positive", p.replicas)
+	}
+	if p.timeout < 0 {
+		return fmt.Errorf("invalid timeout %v", p.timeout)
+	}
+	if p.pollTimeout <= 0 {
+		return fmt.Errorf("invalid poll timeout %v", p.pollTimeout)
+	}
+	if p.registry == nil {
+		return fmt.Errorf("nil registry")
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package gacache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroupOptions(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	invalid := [][]GroupOption{
+		{WithHotCacheRatio(-0.1)},
+		{WithHotCacheRatio(1)},
+		{WithEvictionPolicy(EvictionPolicy(10))},
+		{WithTTL(-time.Second)},
+		{WithClock(nil)},
+	}
+	for _, opts := range invalid {
+		if _, err := NewGroupWithOptions("options", 2<<10, getter, opts...); err == nil {
+			t.Fatalf("invalid options should return error")
+		}
+	}
+	if _, err := NewGroupWithOptions("options", 2<<10, nil); err == nil {
+		t.Fatalf("nil Getter should return error")
+	}
+	g, err := NewGroupWithOptions("options", 1000, getter, WithHotCacheRatio(0.2), WithEvictionPolicy(FIFO))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteGroup("options")
+	if g.mainCache.cacheBytes != 800 || g.hotCache.cacheBytes != 200 || g.mainCache.policy != FIFO {
+		t.Fatalf("options not applied, main %d hot %d", g.mainCache.cacheBytes, g.hotCache.cacheBytes)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	loads := 0
+	g := NewGroup("ttl", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}), WithTTL(50*time.Millisecond))
+	defer DeleteGroup("ttl")
+	g.Get("Tom")
+	g.Get("Tom")
+	if loads != 1 {
+		t.Fatalf("Tom should be cached, but loaded %d times", loads)
+	}
+	time.Sleep(100 * time.Millisecond)
+	g.Get("Tom")
+	if loads != 2 {
+		t.Fatalf("Tom should be expired, but loaded %d times", loads)
+	}
+}
+
+func TestHTTPPoolOptions(t *testing.T) {
+	invalid := [][]HTTPPoolOption{
+		{WithBasePath("_gacache/")},
+		{WithBasePath("/_gacache")},
+		{WithReplicas(0)},
+		{WithPollTimeout(0)},
+	}
+	for _, opts := range invalid {
+		if _, err := NewHTTPPoolWithOptions("http://localhost:8001", opts...); err == nil {
+			t.Fatalf("invalid options should return error")
+		}
+	}
+	p, err := NewHTTPPoolWithOptions("http://localhost:8001", WithBasePath("/cache/"), WithReplicas(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Set("http://localhost:8001", "http://localhost:8002")
+	if g := p.httpGetters["http://localhost:8002"]; g == nil || g.baseURL != "http://localhost:8002/cache/" {
+		t.Fatalf("base path not applied")
+	}
+}
@@ -1,7 +1,9 @@
 package gacache
 
 import (
+	"context"
 	pb "gacache/gacachepb"
+	"io"
 )
 
 //顾名思义，节点选择接口
@@ -13,3 +15,24 @@ type PeerPicker interface {
 type PeerGetter interface {
 	Get(in *pb.Request, out *pb.Response) error
 }
+
+//可选的节点接口,支持流式返回数据,调用者负责关闭返回的ReadCloser
+type PeerStreamer interface {
+	GetStream(in *pb.Request) (io.ReadCloser, error)
+}
+
+//可选的节点接口,支持把写入请求转发给key所属的节点,以及删除节点hotCache中的副本
+type PeerSetter interface {
+	Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error
+	Invalidate(ctx context.Context, in *pb.Request) error
+}
+
+//可选的节点接口,支持通知节点更新命名空间的代数
+type PeerNamespacer interface {
+	BumpNamespace(ctx context.Context, in *pb.Request) error
+}
+
+//可选的PeerPicker接口,返回除了自己之外的所有节点,用于广播
+type PeerLister interface {
+	Peers() []PeerGetter
+}
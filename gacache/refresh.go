@@ -0,0 +1,83 @@
+package gacache
+
+import (
+	"log"
+	"time"
+)
+
+//refresh-ahead任务队列的长度,队列满了之后新的刷新任务直接丢弃
+const refreshQueueSize = 1024
+
+//启动refresh-ahead的worker
+func (g *Group) startRefreshWorkers() {
+	g.refreshQueue = make(chan string, refreshQueueSize)
+	for i := 0; i < g.opts.refreshWorkers; i++ {
+		go g.refreshWorker()
+	}
+}
+
+func (g *Group) refreshWorker() {
+	for {
+		select {
+		case key := <-g.refreshQueue:
+			g.refreshAhead(key)
+		case <-g.stop:
+			return
+		}
+	}
+}
+
+//访问频繁并且快要过期的key提前在后台刷新,保证热点key不会出现同步的cache miss
+//只有mainCache中的数据才会提前刷新,hotCache中的数据由所属的节点负责刷新
+func (g *Group) maybeRefreshAhead(key string, v ByteView) {
+	if g.refreshQueue == nil || v.n == nil || v.e.IsZero() {
+		return
+	}
+	v.n.Add(1)
+	window := time.Duration(float64(g.opts.ttl) * g.opts.refreshWindow)
+	if v.e.Sub(g.opts.now()) > window || v.n.Get() < g.opts.refreshMinAccess {
+		return
+	}
+	g.refreshMu.Lock()
+	if g.refreshing[key] {
+		g.refreshMu.Unlock()
+		return
+	}
+	select {
+	case g.refreshQueue <- key:
+		g.refreshing[key] = true
+	default:
+		//队列满了,等下一次访问再尝试
+	}
+	g.refreshMu.Unlock()
+}
+
+//从数据源重新加载,和同步的加载共用singleflight
+func (g *Group) refreshAhead(key string) {
+	defer func() {
+		g.refreshMu.Lock()
+		delete(g.refreshing, key)
+		g.refreshMu.Unlock()
+	}()
+	_, err := g.loader.Do(key, func() (interface{}, error) {
+		return g.getLocally(key)
+	})
+	if err != nil {
+		log.Println("[Gacache] Fail to refresh ahead", key, err)
+		return
+	}
+	g.Stats.RefreshAheads.Add(1)
+}
+
+//停止Group的后台任务,写入write-behind队列中剩下的数据,关闭磁盘二级缓存
+func (g *Group) close() {
+	g.closeOnce.Do(func() {
+		close(g.stop)
+		if g.writer != nil {
+			<-g.writer.done
+		}
+		if g.disk != nil {
+			g.disk.Close()
+		}
+	})
+}
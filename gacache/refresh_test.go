@@ -0,0 +1,64 @@
+package gacache
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshAhead(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	if _, err := NewGroupWithOptions("refresh", 0, getter, WithRefreshAhead(0.5, 3, 2)); err == nil {
+		t.Fatalf("refresh-ahead without ttl should fail")
+	}
+	if _, err := NewGroupWithOptions("refresh", 0, getter, WithTTL(time.Second), WithRefreshAhead(1, 3, 2)); err == nil {
+		t.Fatalf("invalid refresh window should fail")
+	}
+	var loads int32
+	clock := &testClock{}
+	g := NewGroup("refresh", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte(key), nil
+	}), WithTTL(200*time.Millisecond), WithRefreshAhead(0.5, 3, 2), WithClock(clock.now))
+	defer DeleteGroup("refresh")
+	g.Get("Tom")
+	g.Get("Jack")
+	refreshed := make(chan string, 10)
+	defer g.Subscribe(func(e Event) {
+		if e.Type == EventLocalLoad {
+			refreshed <- e.Key
+		}
+	})()
+	clock.advance(120 * time.Millisecond)
+	//Tom访问频繁,进入刷新窗口之后会被提前刷新,Jack访问次数不够
+	for i := 0; i < 3; i++ {
+		g.Get("Tom")
+	}
+	g.Get("Jack")
+	select {
+	case key := <-refreshed:
+		if key != "Tom" {
+			t.Fatalf("only Tom should be refreshed, but got %s", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Tom should be refreshed ahead")
+	}
+	//加载完成之后才会计数
+	for g.Stats.RefreshAheads.Get() == 0 {
+		runtime.Gosched()
+	}
+	if n := atomic.LoadInt32(&loads); n != 3 || g.Stats.RefreshAheads.Get() != 1 {
+		t.Fatalf("Tom should be refreshed ahead once, loads %d", n)
+	}
+	//原来的过期时间已经过了,Tom依然命中,Jack需要同步加载
+	clock.advance(110 * time.Millisecond)
+	hits := g.Stats.MainCacheHits.Get()
+	g.Get("Tom")
+	g.Get("Jack")
+	if g.Stats.MainCacheHits.Get() != hits+1 || atomic.LoadInt32(&loads) != 4 {
+		t.Fatalf("Tom should hit after refresh ahead")
+	}
+}
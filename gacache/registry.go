@@ -0,0 +1,178 @@
+package gacache
+
+import (
+	"fmt"
+	"gacache/diskcache"
+	"gacache/singleflight"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Registry管理一组Group以及它们共用的PeerPicker
+//同一个进程中可以有多个互相独立的Registry,比如在一个测试中启动多个节点
+type Registry struct {
+	mu     sync.RWMutex
+	groups map[string]*Group
+	peers  PeerPicker
+	broker Broker
+	//Group创建之后的回调
+	newGroupHooks []func(*Group)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*Group)}
+}
+
+//注册Group创建之后的回调,比如HTTP层和监控可以借此自动注册新的Group
+//回调不会在持有锁的时候调用,可以在回调中调用GetGroup等方法
+func (r *Registry) RegisterNewGroupHook(fn func(*Group)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.newGroupHooks = append(r.newGroupHooks, fn)
+}
+
+//为Registry中已有的和之后创建的所有Group注册PeerPicker
+func (r *Registry) RegisterPeers(peers PeerPicker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.peers != nil {
+		panic("RegisterPeers called more than once ! ! !")
+	}
+	r.peers = peers
+	for _, g := range r.groups {
+		if g.peers == nil {
+			g.peers = peers
+		}
+	}
+}
+
+//为Registry中已有的和之后创建的所有Group注册Broker,同时订阅其他节点发布的失效通知
+func (r *Registry) RegisterBroker(b Broker) {
+	r.mu.Lock()
+	if r.broker != nil {
+		r.mu.Unlock()
+		panic("RegisterBroker called more than once ! ! !")
+	}
+	r.broker = b
+	for _, g := range r.groups {
+		g.broker = b
+	}
+	r.mu.Unlock()
+	b.Subscribe(r.invalidate)
+}
+
+//新建Group,配置不合法的时候会panic
+func (r *Registry) NewGroup(name string, cacheByte int64, getter Getter, opts ...GroupOption) *Group {
+	g, err := r.NewGroupWithOptions(name, cacheByte, getter, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+//新建Group,配置不合法或者重名的时候返回error
+func (r *Registry) NewGroupWithOptions(name string, cacheByte int64, getter Getter, opts ...GroupOption) (*Group, error) {
+	if getter == nil {
+		return nil, fmt.Errorf("nil Getter")
+	}
+	if name == "" || strings.HasPrefix(name, "_") {
+		//以'_'开头的名字保留给节点之间的控制请求,比如_dump
+		return nil, fmt.Errorf("invalid group name %q", name)
+	}
+	if cacheByte < 0 {
+		return nil, fmt.Errorf("invalid cache bytes %d", cacheByte)
+	}
+	o, err := newGroupOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.storage == ArenaStorage && cacheByte == 0 {
+		return nil, fmt.Errorf("arena storage requires cache bytes")
+	}
+	mainBytes, hotBytes, err := splitCacheBytes(cacheByte, o.hotCacheRatio)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if _, ok := r.groups[name]; ok {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("duplicate group %s", name)
+	}
+	g := &Group{
+		name:       name,
+		getter:     getter,
+		mainCache:  cache{cacheBytes: mainBytes, policy: o.policy, storage: o.storage, overhead: o.entryOverhead, now: o.now},
+		hotCache:   cache{cacheBytes: hotBytes, policy: o.policy, storage: o.storage, overhead: o.entryOverhead, now: o.now},
+		peers:      r.peers,
+		broker:     r.broker,
+		loader:     &singleflight.Group{},
+		keys:       map[string]*KeyStats{},
+		refreshing: map[string]bool{},
+		stop:       make(chan struct{}),
+		opts:       o,
+	}
+	g.mainCache.ns = &g.ns
+	g.hotCache.ns = &g.ns
+	if o.diskBytes > 0 {
+		//在检查重名之后打开,Open会清空目录中已有的数据
+		if g.disk, err = diskcache.Open(filepath.Join(o.diskDir, name), o.diskBytes, 0); err != nil {
+			r.mu.Unlock()
+			return nil, fmt.Errorf("open disk cache: %v", err)
+		}
+		g.disk.SetClock(o.now)
+	}
+	g.mainCache.onEvicted = func(key string, value ByteView, reason EvictReason) {
+		g.evicted(key, value, reason, false)
+	}
+	g.hotCache.onEvicted = func(key string, value ByteView, reason EvictReason) {
+		g.evicted(key, value, reason, true)
+	}
+	if o.refreshWorkers > 0 {
+		g.startRefreshWorkers()
+	}
+	if o.writeInterval > 0 {
+		g.startWriteBehind()
+	}
+	r.groups[name] = g
+	hooks := r.newGroupHooks
+	r.mu.Unlock()
+	for _, fn := range hooks {
+		fn(g)
+	}
+	return g, nil
+}
+
+//获取Group
+func (r *Registry) GetGroup(name string) *Group {
+	r.mu.RLock() //只读操作，用读锁就ok了
+	defer r.mu.RUnlock()
+	return r.groups[name]
+}
+
+//删除Group并停止它的后台任务,删除之后GetGroup获取不到该Group,已有的引用依然可以访问缓存
+func (r *Registry) DeleteGroup(name string) {
+	r.mu.Lock()
+	g, ok := r.groups[name]
+	delete(r.groups, name)
+	r.mu.Unlock()
+	if ok {
+		//写入write-behind队列可能比较慢,不持有锁
+		g.close()
+	}
+}
+
+//按照名字排序返回所有的Group
+func (r *Registry) ListGroups() []*Group {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	gs := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		gs = append(gs, g)
+	}
+	sort.Slice(gs, func(i, j int) bool {
+		return gs[i].name < gs[j].name
+	})
+	return gs
+}
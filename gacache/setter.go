@@ -0,0 +1,250 @@
+package gacache
+
+import (
+	"context"
+	pb "gacache/gacachepb"
+	"log"
+	"sync"
+	"time"
+)
+
+//Set的回调接口,将数据写入数据源
+type Setter interface {
+	Set(ctx context.Context, key string, value []byte) error
+}
+
+//方便将匿名函数转换成Setter
+type SetterFunc func(ctx context.Context, key string, value []byte) error
+
+func (f SetterFunc) Set(ctx context.Context, key string, value []byte) error {
+	return f(ctx, key, value)
+}
+
+//可选的回调接口,write-behind的时候Setter同时实现了它就一次写入一批数据
+type BatchSetter interface {
+	SetBatch(ctx context.Context, values map[string][]byte) error
+}
+
+//写入数据,key属于远程节点的时候转发给它,由key所属的节点更新mainCache并删除其他节点hotCache中的副本
+//设置了Setter的时候同时写入数据源: write-through模式同步写入,写入失败不会更新缓存
+//write-behind模式先更新缓存,再由后台批量写入
+func (g *Group) Set(ctx context.Context, key string, value []byte) error {
+	_, err := g.write(ctx, &pb.SetRequest{Key: key, Value: value})
+	return err
+}
+
+//Set和CompareAndSet的公共部分,返回新的版本号
+func (g *Group) write(ctx context.Context, in *pb.SetRequest) (uint64, error) {
+	if err := g.checkKey(in.Key); err != nil {
+		return 0, err
+	}
+	g.Stats.Sets.Add(1)
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(in.Key); ok {
+			s, ok := peer.(PeerSetter)
+			if !ok {
+				return 0, NewError(CodeBadRequest, "peer %s does not support set", PeerName(peer))
+			}
+			in.Group = g.name
+			out := &pb.Response{}
+			err := s.Set(ctx, in, out)
+			g.removeFrom(in.Key, &g.hotCache)
+			return out.Version, err
+		}
+	}
+	return g.setLocally(ctx, in)
+}
+
+//作为key所属的节点写入数据,同一个key的写入依次执行
+func (g *Group) setLocally(ctx context.Context, in *pb.SetRequest) (uint64, error) {
+	key := in.Key
+	mu := g.writeLock(key)
+	mu.Lock()
+	if in.Cas {
+		current, err := g.currentVersion(key)
+		if err == nil && current != in.Expected {
+			g.Stats.Conflicts.Add(1)
+			err = NewError(CodeConflict, "version of %s is %d, not %d", key, current, in.Expected)
+		}
+		if err != nil {
+			mu.Unlock()
+			return 0, err
+		}
+	}
+	if g.opts.setter != nil && g.writer == nil {
+		if err := g.opts.setter.Set(ctx, key, in.Value); err != nil {
+			mu.Unlock()
+			g.Stats.WriteErrors.Add(1)
+			return 0, err
+		}
+	}
+	b := cloneBytes(in.Value)
+	if g.disk != nil {
+		g.disk.Remove(g.ns.key(key))
+	}
+	g.removeFrom(key, &g.hotCache)
+	version := versionOf(ByteView{b: b})
+	if !g.putCache(key, ByteView{b: b, v: version}, &g.mainCache) {
+		//新的值放不下,不能继续返回旧值
+		g.removeFrom(key, &g.mainCache)
+	}
+	if g.writer != nil {
+		g.writer.add(key, b)
+	}
+	g.writeSeq[writeStripe(key)]++
+	mu.Unlock()
+	g.publish(ctx, key)
+	return version, nil
+}
+
+//删除其他节点hotCache中的副本,失败只记录日志,副本会在过期或者被淘汰之后消失
+func (g *Group) invalidatePeers(ctx context.Context, key string) {
+	l, ok := g.peers.(PeerLister)
+	if !ok {
+		return
+	}
+	in := &pb.Request{Group: g.name, Key: key}
+	for _, peer := range l.Peers() {
+		if s, ok := peer.(PeerSetter); ok {
+			if err := s.Invalidate(ctx, in); err != nil {
+				log.Println("[Gacache] Fail to invalidate", key, "on", PeerName(peer), err)
+			}
+		}
+	}
+}
+
+//立即将write-behind队列中的数据写入数据源,返回最后一个错误,失败的数据依然会按照重试次数重试
+func (g *Group) Flush(ctx context.Context) error {
+	if g.writer == nil {
+		return nil
+	}
+	return g.writer.flush(ctx)
+}
+
+//write-behind队列,同一个key只保留最新的值
+type writeBehind struct {
+	g       *Group
+	mu      sync.Mutex
+	pending map[string]*pendingWrite
+	flushMu sync.Mutex    //同时只有一个flush,保证同一个key的新值在旧值之后写入
+	kick    chan struct{} //攒够一批之后提前写入
+	done    chan struct{}
+}
+
+type pendingWrite struct {
+	value    []byte
+	attempts int //已经失败的次数
+}
+
+func (g *Group) startWriteBehind() {
+	g.writer = &writeBehind{
+		g:       g,
+		pending: map[string]*pendingWrite{},
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go g.writer.run()
+}
+
+//每隔interval或者攒够一批之后写入,Group关闭的时候写入剩下的数据
+func (w *writeBehind) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.g.opts.writeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.kick:
+		case <-w.g.stop:
+			for i := 0; i <= w.g.opts.writeRetries && w.len() > 0; i++ {
+				w.flush(context.Background())
+			}
+			return
+		}
+		w.flush(context.Background())
+	}
+}
+
+func (w *writeBehind) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending)
+}
+
+func (w *writeBehind) add(key string, value []byte) {
+	w.mu.Lock()
+	w.pending[key] = &pendingWrite{value: value}
+	n := len(w.pending)
+	w.mu.Unlock()
+	if n >= w.g.opts.writeBatch {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//按照writeBatch分批写入队列中的数据,失败的数据重新放回队列,除非已经有了更新的值
+func (w *writeBehind) flush(ctx context.Context) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = map[string]*pendingWrite{}
+	w.mu.Unlock()
+	var lastErr error
+	batch := make(map[string]*pendingWrite, w.g.opts.writeBatch)
+	for key, p := range pending {
+		batch[key] = p
+		if len(batch) == w.g.opts.writeBatch {
+			if err := w.write(ctx, batch); err != nil {
+				lastErr = err
+			}
+			batch = make(map[string]*pendingWrite, w.g.opts.writeBatch)
+		}
+	}
+	if len(batch) > 0 {
+		if err := w.write(ctx, batch); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+//Setter实现了BatchSetter的时候一次写入,否则逐个写入
+func (w *writeBehind) write(ctx context.Context, batch map[string]*pendingWrite) error {
+	var lastErr error
+	if bs, ok := w.g.opts.setter.(BatchSetter); ok {
+		values := make(map[string][]byte, len(batch))
+		for key, p := range batch {
+			values[key] = p.value
+		}
+		if lastErr = bs.SetBatch(ctx, values); lastErr != nil {
+			for key, p := range batch {
+				w.retry(key, p, lastErr)
+			}
+		}
+		return lastErr
+	}
+	for key, p := range batch {
+		if err := w.g.opts.setter.Set(ctx, key, p.value); err != nil {
+			lastErr = err
+			w.retry(key, p, err)
+		}
+	}
+	return lastErr
+}
+
+func (w *writeBehind) retry(key string, p *pendingWrite, err error) {
+	w.g.Stats.WriteErrors.Add(1)
+	if p.attempts++; p.attempts > w.g.opts.writeRetries {
+		w.g.Stats.WriteDrops.Add(1)
+		log.Println("[Gacache] Drop write-behind", key, err)
+		return
+	}
+	w.mu.Lock()
+	if _, ok := w.pending[key]; !ok {
+		w.pending[key] = p
+	}
+	w.mu.Unlock()
+}
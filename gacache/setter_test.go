@@ -0,0 +1,176 @@
+package gacache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteThrough(t *testing.T) {
+	fail := errors.New("db down")
+	var err error
+	g := NewRegistry().NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("630"), nil
+	}), WithSetter(SetterFunc(func(ctx context.Context, key string, value []byte) error {
+		return err
+	})), WithMaxEntryBytes(32))
+	g.Get("Tom")
+	value := []byte("589")
+	if e := g.Set(context.Background(), "Tom", value); e != nil {
+		t.Fatal(e)
+	}
+	value[0] = '0'
+	if v, _ := g.Get("Tom"); v.String() != "589" || g.Stats.LocalLoads.Get() != 1 {
+		t.Fatalf("Tom should be updated without reload")
+	}
+	//写入数据源失败的时候不更新缓存
+	err = fail
+	if e := g.Set(context.Background(), "Tom", []byte("0")); e != fail || g.Stats.WriteErrors.Get() != 1 {
+		t.Fatalf("set should fail")
+	}
+	if v, _ := g.Get("Tom"); v.String() != "589" {
+		t.Fatalf("Tom should not be updated")
+	}
+	//新的值放不下的时候删除旧值
+	err = nil
+	g.Set(context.Background(), "Tom", make([]byte, 64))
+	if _, ok := g.mainCache.get("Tom"); ok {
+		t.Fatalf("old value should be removed")
+	}
+	if g.Set(context.Background(), "", nil) == nil {
+		t.Fatalf("empty key should fail")
+	}
+}
+
+func TestSetDuringLoad(t *testing.T) {
+	var mu sync.Mutex
+	db := map[string]string{"Tom": "630"}
+	loading, release := make(chan struct{}), make(chan struct{})
+	g := NewRegistry().NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		mu.Lock()
+		v := db[key]
+		mu.Unlock()
+		//读到旧值之后等待Set完成
+		close(loading)
+		<-release
+		return []byte(v), nil
+	}), WithSetter(SetterFunc(func(ctx context.Context, key string, value []byte) error {
+		mu.Lock()
+		db[key] = string(value)
+		mu.Unlock()
+		return nil
+	})))
+	done := make(chan struct{})
+	go func() {
+		g.Get("Tom")
+		close(done)
+	}()
+	<-loading
+	if err := g.Set(context.Background(), "Tom", []byte("589")); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-done
+	//加载到的旧值不能覆盖Set写入的新值
+	if v, _ := g.Get("Tom"); v.String() != "589" {
+		t.Fatalf("Tom = %s, the value loaded before Set should not be cached", v.String())
+	}
+}
+
+//记录每一批写入的数据,前fails次写入失败
+type batchSetter struct {
+	mu      sync.Mutex
+	fails   int
+	batches []map[string]string
+}
+
+func (s *batchSetter) Set(ctx context.Context, key string, value []byte) error {
+	panic("SetBatch should be used")
+}
+
+func (s *batchSetter) SetBatch(ctx context.Context, values map[string][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("db down")
+	}
+	batch := map[string]string{}
+	for key, value := range values {
+		batch[key] = string(value)
+	}
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *batchSetter) written() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.batches
+}
+
+func TestWriteBehind(t *testing.T) {
+	r := NewRegistry()
+	s := &batchSetter{fails: 1}
+	g := r.NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("630"), nil
+	}), WithSetter(s), WithWriteBehind(time.Hour, 3, 1))
+	ctx := context.Background()
+	g.Set(ctx, "Tom", []byte("1"))
+	g.Set(ctx, "Tom", []byte("2"))
+	//Set之后立即返回新值,同一个key只写入最新的值
+	if v, _ := g.Get("Tom"); v.String() != "2" || len(s.written()) != 0 {
+		t.Fatalf("write-behind should update cache first")
+	}
+	//第一次写入失败,重新放回队列
+	if err := g.Flush(ctx); err == nil || g.Stats.WriteErrors.Get() != 1 {
+		t.Fatalf("first flush should fail")
+	}
+	g.Set(ctx, "Jack", []byte("3"))
+	g.Set(ctx, "Sam", []byte("4")) //攒够3条之后在后台写入
+	deadline := time.Now().Add(time.Second)
+	for len(s.written()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	expect := []map[string]string{{"Tom": "2", "Jack": "3", "Sam": "4"}}
+	if !reflect.DeepEqual(s.written(), expect) {
+		t.Fatalf("expect batches %v, but got %v", expect, s.written())
+	}
+
+	//超过重试次数之后丢弃
+	s.mu.Lock()
+	s.fails = 2
+	s.mu.Unlock()
+	g.Set(ctx, "Tom", []byte("5"))
+	g.Flush(ctx)
+	g.Flush(ctx)
+	if g.Stats.WriteDrops.Get() != 1 || g.Flush(ctx) != nil || len(s.written()) != 1 {
+		t.Fatalf("Tom should be dropped after retries")
+	}
+
+	//关闭的时候写入剩下的数据
+	for i := 0; i < 2; i++ {
+		g.Set(ctx, strconv.Itoa(i), []byte(strconv.Itoa(i)))
+	}
+	r.DeleteGroup("scores")
+	expect = append(expect, map[string]string{"0": "0", "1": "1"})
+	if !reflect.DeepEqual(s.written(), expect) {
+		t.Fatalf("pending writes should be flushed on close, got %v", s.written())
+	}
+
+	for _, opt := range []GroupOption{
+		WithWriteBehind(time.Second, 1, 0),
+		WithWriteBehind(-time.Second, 1, 0),
+	} {
+		if _, err := NewRegistry().NewGroupWithOptions("scores", 2<<10, g.getter, opt); err == nil {
+			t.Fatalf("invalid write-behind options should fail")
+		}
+	}
+	if _, err := NewRegistry().NewGroupWithOptions("scores", 2<<10, g.getter, WithSetter(s), WithWriteBehind(time.Second, 0, 0)); err == nil {
+		t.Fatalf("invalid write-behind batch should fail")
+	}
+}
@@ -1,6 +1,12 @@
 package singleflight
 
-import "sync"
+import (
+	"errors"
+	"sync"
+)
+
+//fn发生panic时其他等待的请求收到的错误
+var errPanic = errors.New("singleflight: fn panicked")
 
 //封装每个请求/调用
 type call struct {
@@ -29,12 +35,16 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, err
 	c := new(call)
 	c.wg.Add(1)
 	g.m[key] = c
-	g.mu.Unlock()       //这里释放锁，让其他请求进入上面的分支中wait(其实只有并发量大的时候才会进入上面的分支)
+	g.mu.Unlock() //这里释放锁，让其他请求进入上面的分支中wait(其实只有并发量大的时候才会进入上面的分支)
+	//fn发生panic的时候也要唤醒其他请求并删除key,否则其他请求会永远阻塞
+	defer func() {
+		c.wg.Done() //获取到值,第一个请求结束,其他请求可以获取到值了
+		//删除m中的key,避免key发生变化,而取到的还是旧值
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+	}()
+	c.err = errPanic    //fn正常返回的时候会被覆盖
 	c.val, c.err = fn() //请求数据
-	c.wg.Done()         //获取到值,第一个请求结束,其他请求可以获取到值了
-	//删除m中的key,避免key发生变化,而取到的还是旧值
-	g.mu.Lock()
-	delete(g.m, key)
-	g.mu.Unlock()
 	return c.val, c.err
 }
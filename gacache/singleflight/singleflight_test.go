@@ -0,0 +1,65 @@
+package singleflight
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := g.Do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return "bar", nil
+			})
+			if err != nil || v.(string) != "bar" {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("fn should be called once, but called %d times", n)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	waiter := make(chan error)
+	go func() {
+		defer func() { recover() }()
+		g.Do("key", func() (interface{}, error) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			panic("boom")
+		})
+	}()
+	<-started
+	go func() {
+		_, err := g.Do("key", func() (interface{}, error) {
+			return "bar", nil
+		})
+		waiter <- err
+	}()
+	//panic之后等待的请求不能阻塞
+	select {
+	case <-waiter:
+	case <-time.After(time.Second):
+		t.Fatalf("waiter blocked after fn panicked")
+	}
+	//key被删除,之后的请求可以正常执行
+	if v, err := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	}); err != nil || v.(string) != "bar" {
+		t.Fatalf("Do after panic = %v, %v", v, err)
+	}
+}
@@ -0,0 +1,166 @@
+package gacache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	pb "gacache/gacachepb"
+	"hash/crc32"
+	"io"
+)
+
+//快照格式:
+//magic(4) | version(1) | count(uvarint) | entry * count | crc32(4,大端序,校验前面所有的数据)
+//entry: keyLen(uvarint) | key | valueLen(uvarint) | value | ttl(uvarint,剩余的过期时间,纳秒,0代表不过期)
+//entry按照从最旧到最新的顺序写入,恢复的时候依次写入即可还原LRU顺序
+const (
+	snapshotMagic   = "GACS"
+	snapshotVersion = 1
+	//单个key或者value的最大长度,防止损坏的快照申请过多内存
+	maxSnapshotField = 1 << 30
+)
+
+var ErrBadSnapshot = errors.New("gacache: bad snapshot")
+
+//将mainCache中没有过期的数据写入w,返回写入的数量
+func (g *Group) Snapshot(w io.Writer) (int, error) {
+	entries := g.dump(nil).Entries
+	now := g.opts.now().UnixNano()
+	h := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, h))
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(x uint64) {
+		bw.Write(buf[:binary.PutUvarint(buf, x)])
+	}
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+	putUvarint(uint64(len(entries)))
+	for _, e := range entries {
+		var ttl int64
+		if e.Expire != 0 {
+			//至少保留1ns,避免被当成不过期
+			if ttl = e.Expire - now; ttl < 1 {
+				ttl = 1
+			}
+		}
+		putUvarint(uint64(len(e.Key)))
+		bw.WriteString(e.Key)
+		putUvarint(uint64(len(e.Value)))
+		bw.Write(e.Value)
+		putUvarint(uint64(ttl))
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, h.Sum32())
+	if _, err := w.Write(sum); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+//从r中恢复Snapshot写入的数据,校验通过之后才会写入mainCache,已经存在的key不会覆盖
+//返回写入的数量
+func (g *Group) Restore(r io.Reader) (int, error) {
+	h := crc32.NewIEEE()
+	br := bufio.NewReader(r)
+	tr := &byteReader{r: br, h: h}
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(tr, header); err != nil {
+		return 0, badSnapshot(err)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, fmt.Errorf("%w: invalid magic", ErrBadSnapshot)
+	}
+	if v := header[len(snapshotMagic)]; v != snapshotVersion {
+		return 0, fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, v)
+	}
+	count, err := binary.ReadUvarint(tr)
+	if err != nil {
+		return 0, badSnapshot(err)
+	}
+	var entries []*pb.Entry
+	for i := uint64(0); i < count; i++ {
+		key, err := readField(tr)
+		if err != nil {
+			return 0, badSnapshot(err)
+		}
+		value, err := readField(tr)
+		if err != nil {
+			return 0, badSnapshot(err)
+		}
+		ttl, err := binary.ReadUvarint(tr)
+		if err != nil {
+			return 0, badSnapshot(err)
+		}
+		e := &pb.Entry{Key: string(key), Value: value}
+		if ttl != 0 {
+			e.Expire = int64(ttl)
+		}
+		entries = append(entries, e)
+	}
+	expect := h.Sum32()
+	sum := make([]byte, 4)
+	if _, err := io.ReadFull(br, sum); err != nil {
+		return 0, badSnapshot(err)
+	}
+	if binary.BigEndian.Uint32(sum) != expect {
+		return 0, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+	//剩余的过期时间从恢复的时刻开始计算
+	now := g.opts.now().UnixNano()
+	restored := 0
+	for _, e := range entries {
+		if e.Expire != 0 {
+			e.Expire += now
+		}
+		if g.fill(e) {
+			restored++
+		}
+	}
+	return restored, nil
+}
+
+func badSnapshot(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+}
+
+func readField(r *byteReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSnapshotField {
+		return nil, fmt.Errorf("field too large: %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//读取的同时计算校验和
+type byteReader struct {
+	r *bufio.Reader
+	h io.Writer
+}
+
+func (r *byteReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.h.Write([]byte{b})
+	}
+	return b, err
+}
@@ -0,0 +1,59 @@
+package gacache
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	r := NewRegistry()
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	})
+	g := r.NewGroup("scores", 2<<10, getter, WithTTL(time.Hour))
+	for _, key := range []string{"Tom", "Jack", "Sam"} {
+		g.Get(key)
+	}
+	g.Get("Tom") //Jack最久未使用
+	var buf bytes.Buffer
+	if n, err := g.Snapshot(&buf); err != nil || n != 3 {
+		t.Fatalf("snapshot fail: %d %v", n, err)
+	}
+	data := buf.Bytes()
+
+	//只能放下两个,恢复之后LRU顺序不变,Jack被淘汰
+	restored := r.NewGroup("restored", 2*int64(len("Tom")+len("Tom-value")), getter, WithTTL(time.Hour), WithHotCacheRatio(0))
+	if n, err := restored.Restore(bytes.NewReader(data)); err != nil || n != 3 {
+		t.Fatalf("restore fail: %d %v", n, err)
+	}
+	for _, key := range []string{"Tom", "Sam"} {
+		v, ok := restored.mainCache.get(key)
+		if !ok || v.String() != key+"-value" {
+			t.Fatalf("%s should be restored", key)
+		}
+		if d := time.Until(v.Expire()); d <= 0 || d > time.Hour {
+			t.Fatalf("%s should keep the remaining ttl, got %v", key, d)
+		}
+	}
+	if _, ok := restored.mainCache.get("Jack"); ok {
+		t.Fatalf("Jack should be evicted by lru order")
+	}
+
+	//损坏的快照不会写入任何数据
+	for i := range data {
+		bad := append([]byte(nil), data...)
+		bad[i] ^= 0xff
+		empty := NewRegistry().NewGroup("scores", 2<<10, getter)
+		if _, err := empty.Restore(bytes.NewReader(bad)); !errors.Is(err, ErrBadSnapshot) {
+			t.Fatalf("corrupt byte %d should fail, got %v", i, err)
+		}
+		if _, ok := empty.mainCache.get("Tom"); ok {
+			t.Fatalf("corrupt snapshot should not be restored")
+		}
+	}
+	if _, err := g.Restore(bytes.NewReader(data[:len(data)-1])); !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("truncated snapshot should fail, got %v", err)
+	}
+}
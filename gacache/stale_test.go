@@ -0,0 +1,60 @@
+package gacache
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	if _, err := NewGroupWithOptions("swr", 0, GetterFunc(nil), WithTTL(time.Second), WithStaleWhileRevalidate(time.Second)); err == nil {
+		t.Fatalf("soft ttl should be less than ttl")
+	}
+	var loads int32
+	release := make(chan struct{})
+	clock := &testClock{}
+	g := NewGroup("swr", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		n := atomic.AddInt32(&loads, 1)
+		if n == 2 {
+			//后台刷新一直进行到测试放行为止
+			<-release
+		}
+		return []byte(strconv.Itoa(int(n))), nil
+	}), WithTTL(300*time.Millisecond), WithStaleWhileRevalidate(50*time.Millisecond), WithClock(clock.now))
+	defer DeleteGroup("swr")
+	loaded := make(chan string, 10)
+	defer g.Subscribe(func(e Event) {
+		if e.Type == EventLocalLoad {
+			loaded <- e.Value.String()
+		}
+	})()
+	if v, _ := g.Get("Tom"); v.String() != "1" {
+		t.Fatalf("expect 1, but got %s", v)
+	}
+	<-loaded
+	clock.advance(80 * time.Millisecond)
+	//软过期之后立即返回旧值,后台只会刷新一次
+	for i := 0; i < 5; i++ {
+		if v, _ := g.Get("Tom"); v.String() != "1" {
+			t.Fatalf("stale value should be served, but got %s", v)
+		}
+	}
+	close(release)
+	select {
+	case v := <-loaded:
+		if v != "2" {
+			t.Fatalf("expect refreshed value 2, but got %s", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("value should be refreshed in background")
+	}
+	if v, _ := g.Get("Tom"); v.String() != "2" || atomic.LoadInt32(&loads) != 2 {
+		t.Fatalf("value should be refreshed once in background, got %s after %d loads", v, loads)
+	}
+	//硬过期之后不再返回旧值
+	clock.advance(350 * time.Millisecond)
+	if v, _ := g.Get("Tom"); v.String() != "3" {
+		t.Fatalf("expired value should not be served, but got %s", v)
+	}
+}
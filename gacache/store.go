@@ -0,0 +1,134 @@
+package gacache
+
+import (
+	"encoding/binary"
+	"gacache/arena"
+	"gacache/lru"
+	"time"
+)
+
+//cache底层的存储,lru.Cache或者arenaStore,都不是并发安全的
+type store interface {
+	Get(key string) (value lru.Value, ok bool)
+	Put(key string, value lru.Value)
+	Remove(key string)
+	Range(fn func(key string, value lru.Value) bool)
+	SetMaxBytes(maxBytes int64)
+	Len() int
+	Bytes() int64
+}
+
+//根据存储引擎和淘汰策略创建底层的存储
+func (c *cache) newStore() store {
+	var onEvicted func(key string, value lru.Value)
+	if c.onEvicted != nil {
+		onEvicted = c.collect
+	}
+	if c.storage == ArenaStorage {
+		return newArenaStore(c.cacheBytes, c.policy, onEvicted)
+	}
+	s := c.policy.newCache(c.cacheBytes, onEvicted)
+	s.SetEntryOverhead(c.overhead)
+	return s
+}
+
+//ByteView在arena中的编码: 过期时间(8) | 软过期时间(8) | 版本号(8) | chunk数量(4) | 压缩算法名字的长度(1) | 压缩算法 | 数据
+const arenaHeaderSize = 29
+
+//arenaStore 将ByteView编码之后存入arena.Cache,取出的时候拷贝一份
+//访问次数(ByteView.n)没有办法存入arena,所以不支持refresh-ahead
+type arenaStore struct {
+	a *arena.Cache
+}
+
+func newArenaStore(maxBytes int64, policy EvictionPolicy, onEvicted func(key string, value lru.Value)) *arenaStore {
+	var fn func(key string, value []byte)
+	if onEvicted != nil {
+		fn = func(key string, value []byte) {
+			onEvicted(key, decodeArena(value))
+		}
+	}
+	if policy == FIFO {
+		return &arenaStore{arena.NewFIFO(maxBytes, fn)}
+	}
+	return &arenaStore{arena.New(maxBytes, fn)}
+}
+
+func encodeArena(v ByteView) []byte {
+	var name string
+	if v.c != nil {
+		name = v.c.Name()
+	}
+	b := make([]byte, arenaHeaderSize+len(name)+len(v.b))
+	if !v.e.IsZero() {
+		binary.LittleEndian.PutUint64(b, uint64(v.e.UnixNano()))
+	}
+	if !v.s.IsZero() {
+		binary.LittleEndian.PutUint64(b[8:], uint64(v.s.UnixNano()))
+	}
+	binary.LittleEndian.PutUint64(b[16:], v.v)
+	binary.LittleEndian.PutUint32(b[24:], uint32(v.k))
+	b[28] = byte(len(name))
+	copy(b[arenaHeaderSize+copy(b[arenaHeaderSize:], name):], v.b)
+	return b
+}
+
+//解码并拷贝数据,b指向arena的缓冲区,之后可能会被覆盖
+func decodeArena(b []byte) ByteView {
+	var v ByteView
+	if e := int64(binary.LittleEndian.Uint64(b)); e != 0 {
+		v.e = time.Unix(0, e)
+	}
+	if s := int64(binary.LittleEndian.Uint64(b[8:])); s != 0 {
+		v.s = time.Unix(0, s)
+	}
+	v.v = binary.LittleEndian.Uint64(b[16:])
+	v.k = int(binary.LittleEndian.Uint32(b[24:]))
+	n := arenaHeaderSize + int(b[28])
+	if n > arenaHeaderSize {
+		//WithStorage要求压缩算法已经注册
+		v.c, _ = codecOf(string(b[arenaHeaderSize:n]))
+	}
+	v.b = cloneBytes(b[n:])
+	return v
+}
+
+func (s *arenaStore) Get(key string) (lru.Value, bool) {
+	b, ok := s.a.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return decodeArena(b), true
+}
+
+func (s *arenaStore) Put(key string, value lru.Value) {
+	s.a.Put(key, encodeArena(value.(ByteView)))
+}
+
+func (s *arenaStore) Remove(key string) {
+	s.a.Remove(key)
+}
+
+func (s *arenaStore) Range(fn func(key string, value lru.Value) bool) {
+	s.a.Range(func(key string, value []byte) bool {
+		return fn(key, decodeArena(value))
+	})
+}
+
+func (s *arenaStore) SetMaxBytes(maxBytes int64) {
+	s.a.SetMaxBytes(maxBytes)
+}
+
+func (s *arenaStore) Len() int {
+	return s.a.Len()
+}
+
+func (s *arenaStore) Bytes() int64 {
+	return s.a.Bytes()
+}
+
+//检查接口
+var (
+	_ store = (*lru.Cache)(nil)
+	_ store = (*arenaStore)(nil)
+)
@@ -0,0 +1,93 @@
+package gacache
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArenaStorage(t *testing.T) {
+	value := strings.Repeat(`{"name":"Tom","score":630}`, 20)
+	var evicted []string
+	g := NewRegistry().NewGroup("scores", 4<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	}), WithStorage(ArenaStorage), WithHotCacheRatio(0), WithTTL(time.Minute),
+		WithCompression(Gzip, 64), WithChunkSize(200))
+	g.Subscribe(func(e Event) {
+		if e.Type == EventEvict {
+			evicted = append(evicted, e.Key)
+		}
+	})
+	for i := 0; i < 2; i++ {
+		if v, err := g.Get("Tom"); err != nil || v.String() != value {
+			t.Fatalf("get Tom fail: %v", err)
+		}
+	}
+	if g.Stats.MainCacheHits.Get() != 1 {
+		t.Fatalf("Tom should be cached in arena")
+	}
+	//过期时间,压缩算法和chunk清单都需要保存
+	if v, _ := g.mainCache.get("Tom"); v.k != 3 || v.e.IsZero() {
+		t.Fatalf("metadata should be kept in arena")
+	}
+	if v, _ := g.mainCache.get(chunkKey("Tom", 0)); v.c == nil || v.c.Name() != "gzip" || v.String() != value[:200] {
+		t.Fatalf("codec should be kept in arena")
+	}
+	for i := 0; g.Stats.LocalLoads.Get() < 100; i++ {
+		g.Get(strconv.Itoa(i))
+	}
+	if len(evicted) == 0 || g.CacheBytes() > 4<<10 {
+		t.Fatalf("arena should evict old entries, used %d bytes", g.CacheBytes())
+	}
+	if err := g.SetCacheBytes(0); err == nil {
+		t.Fatalf("arena storage requires cache bytes")
+	}
+	g.SetCacheBytes(1 << 10)
+	if g.CacheBytes() > 1<<10 {
+		t.Fatalf("arena should shrink")
+	}
+
+	getter := GetterFunc(func(key string) ([]byte, error) { return nil, nil })
+	for _, opts := range [][]GroupOption{
+		{WithStorage(Storage(2))},
+		{WithStorage(ArenaStorage), WithTTL(time.Minute), WithRefreshAhead(0.2, 1, 1)},
+		{WithStorage(ArenaStorage), WithCompression(testCodec{}, 0)},
+	} {
+		if _, err := NewRegistry().NewGroupWithOptions("scores", 2<<10, getter, opts...); err == nil {
+			t.Fatalf("invalid storage options should fail")
+		}
+	}
+	if _, err := NewRegistry().NewGroupWithOptions("scores", 0, getter, WithStorage(ArenaStorage)); err == nil {
+		t.Fatalf("arena storage requires cache bytes")
+	}
+}
+
+func TestArenaEvictOnGet(t *testing.T) {
+	var evicted []string
+	g := NewRegistry().NewGroup("scores", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(strings.Repeat("x", 100)), nil
+	}), WithStorage(ArenaStorage), WithHotCacheRatio(0))
+	g.Subscribe(func(e Event) {
+		if e.Type == EventEvict {
+			evicted = append(evicted, e.Key)
+		}
+	})
+	i := 0
+	for ; len(evicted) == 0; i++ {
+		g.Get(strconv.Itoa(i))
+	}
+	//最旧的key被读取时重新写到头部,淘汰的数据在Get返回之前通知
+	oldest := strconv.Itoa(len(evicted))
+	n := len(evicted)
+	if _, ok := g.mainCache.get(oldest); !ok || len(evicted) == n {
+		t.Fatalf("eviction on get should be delivered, evicted %v", evicted)
+	}
+}
+
+//没有注册的压缩算法
+type testCodec struct{}
+
+func (testCodec) Name() string                        { return "test" }
+func (testCodec) Compress(b []byte) ([]byte, error)   { return b, nil }
+func (testCodec) Decompress(b []byte) ([]byte, error) { return b, nil }
@@ -0,0 +1,130 @@
+package gacache
+
+import (
+	"context"
+	"errors"
+	pb "gacache/gacachepb"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"sync"
+	"time"
+)
+
+//写锁的分段数量
+const writeLockStripes = 64
+
+//Meta 数据的元信息
+type Meta struct {
+	Version uint64    //版本号,由数据的内容计算,内容变化之后版本号变化,用于CompareAndSet
+	Expire  time.Time //过期时间,零值代表永不过期或者未知(从远程节点获取的数据)
+}
+
+//可选的接口,PeerStreamer返回的body实现了它的时候可以获取数据的版本号
+type versioned interface {
+	Version() uint64
+}
+
+//和Get一样,同时返回数据的版本号和过期时间
+func (g *Group) GetWithMeta(key string) (ByteView, Meta, error) {
+	v, err := g.lookup(key)
+	if err != nil {
+		return ByteView{}, Meta{}, err
+	}
+	value, err := v.decode()
+	if err != nil {
+		return ByteView{}, Meta{}, err
+	}
+	return value, Meta{Version: v.v, Expire: v.e}, nil
+}
+
+//和GetReader一样,同时返回数据的版本号和过期时间
+func (g *Group) GetReaderWithMeta(key string) (io.Reader, Meta, error) {
+	v, err := g.lookup(key)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	return newChunkReader(v), Meta{Version: v.v, Expire: v.e}, nil
+}
+
+//key当前的版本号等于expected的时候才写入value,返回新的版本号,版本号不一致的时候返回ErrConflict
+//由key所属的节点原子地比较和写入,key不在数据源中的时候版本号是0
+func (g *Group) CompareAndSet(ctx context.Context, key string, expected uint64, value []byte) (uint64, error) {
+	return g.write(ctx, &pb.SetRequest{Key: key, Value: value, Cas: true, Expected: expected})
+}
+
+//数据内容的版本号,内容相同的时候版本号相同
+//这样数据不在缓存中(太大,没有通过准入或者已经被淘汰)的时候,重新加载得到的版本号也不会变化
+func versionOf(v ByteView) uint64 {
+	h := fnv.New64a()
+	if v.p == nil {
+		v.p = []ByteView{v}
+	}
+	for _, part := range v.p {
+		d, err := part.decode()
+		if err != nil {
+			return 0
+		}
+		h.Write(d.b)
+	}
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	//0代表版本号未知
+	return 1
+}
+
+//重新加载的数据的版本号,changed代表缓存中原来有不同的旧值,需要通知其他节点
+func (g *Group) reloadVersion(key string, value ByteView) (version uint64, changed bool) {
+	version = versionOf(value)
+	if old, ok := g.mainCache.get(key); ok {
+		changed = old.v != version
+	}
+	return version, changed
+}
+
+//key在当前节点的版本号,不在缓存中的时候重新加载
+func (g *Group) currentVersion(key string) (uint64, error) {
+	if v, ok := g.mainCache.get(key); ok {
+		return v.v, nil
+	}
+	//调用者持有写锁,不能经过load,加载完成之后存入缓存也需要写锁
+	v, err := g.getFromSource(key)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return versionOf(v), nil
+}
+
+//key所在的分段
+func writeStripe(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key)) % writeLockStripes
+}
+
+//key对应的写锁
+func (g *Group) writeLock(key string) *sync.Mutex {
+	return &g.writeMu[writeStripe(key)]
+}
+
+//key所在分段到目前为止的写入次数,在从数据源加载之前获取
+func (g *Group) writes(key string) uint64 {
+	i := writeStripe(key)
+	g.writeMu[i].Lock()
+	defer g.writeMu[i].Unlock()
+	return g.writeSeq[i]
+}
+
+//加载期间分段没有写入的时候才把加载到的数据存入mainCache
+//否则数据源中读到的可能是Set之前的旧值,会覆盖Set写入的新值
+func (g *Group) populateLoaded(key string, value ByteView, seq uint64) {
+	i := writeStripe(key)
+	g.writeMu[i].Lock()
+	defer g.writeMu[i].Unlock()
+	if g.writeSeq[i] != seq {
+		return
+	}
+	g.populateCache(key, value, &g.mainCache)
+}
@@ -0,0 +1,132 @@
+package gacache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestGetWithMeta(t *testing.T) {
+	g := NewRegistry().NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("630"), nil
+	}), WithChunkSize(2))
+	v, meta, err := g.GetWithMeta("Tom")
+	if err != nil || v.String() != "630" || meta.Version == 0 {
+		t.Fatalf("get Tom fail: %v", err)
+	}
+	//命中缓存的时候版本号不变
+	if _, m, _ := g.GetWithMeta("Tom"); m.Version != meta.Version {
+		t.Fatalf("version should not change on hit")
+	}
+	g.Set(context.Background(), "Tom", []byte("589"))
+	if v, m, _ := g.GetWithMeta("Tom"); v.String() != "589" || m.Version == meta.Version {
+		t.Fatalf("version should change on set")
+	}
+}
+
+//不在缓存中的数据重新加载之后版本号不变,CompareAndSet不会一直冲突
+func TestVersionUncached(t *testing.T) {
+	var mu sync.Mutex
+	db := map[string]string{"Big": "0123456789", "Tom": "630"}
+	g := NewRegistry().NewGroup("scores", 20, GetterFunc(func(key string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return []byte(key), nil
+	}), WithSetter(SetterFunc(func(ctx context.Context, key string, value []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		db[key] = string(value)
+		return nil
+	})), WithMaxEntryBytes(10), WithHotCacheRatio(0))
+	ctx := context.Background()
+	//超过单条数据的大小限制,不会存入缓存
+	_, meta, err := g.GetWithMeta("Big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, m, _ := g.GetWithMeta("Big"); m.Version != meta.Version {
+		t.Fatalf("version of uncached key should not change on reload")
+	}
+	version, err := g.CompareAndSet(ctx, "Big", meta.Version, []byte("9876543210"))
+	if err != nil {
+		t.Fatalf("cas on uncached key should succeed: %v", err)
+	}
+	if _, ok := g.mainCache.get("Big"); ok {
+		t.Fatalf("Big should not be cached")
+	}
+	if v, m, _ := g.GetWithMeta("Big"); v.String() != "9876543210" || m.Version != version {
+		t.Fatalf("reloaded version should be %d, got %d", version, m.Version)
+	}
+	//被淘汰之后重新加载
+	_, meta, _ = g.GetWithMeta("Tom")
+	for i := 0; i < 10; i++ {
+		g.Get("k" + strconv.Itoa(i))
+	}
+	if _, ok := g.mainCache.get("Tom"); ok {
+		t.Fatalf("Tom should be evicted")
+	}
+	if _, err := g.CompareAndSet(ctx, "Tom", meta.Version, []byte("589")); err != nil {
+		t.Fatalf("cas on evicted key should succeed: %v", err)
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	ctx := context.Background()
+	g := NewRegistry().NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "Tom" {
+			return []byte("0"), nil
+		}
+		return nil, ErrNotFound
+	}))
+	_, meta, _ := g.GetWithMeta("Tom")
+	version, err := g.CompareAndSet(ctx, "Tom", meta.Version, []byte("589"))
+	if err != nil || version == meta.Version {
+		t.Fatalf("cas should succeed: %v", err)
+	}
+	//使用旧版本号写入失败
+	if _, err := g.CompareAndSet(ctx, "Tom", meta.Version, []byte("0")); !errors.Is(err, ErrConflict) || g.Stats.Conflicts.Get() != 1 {
+		t.Fatalf("cas with stale version should conflict, got %v", err)
+	}
+	if v, _ := g.Get("Tom"); v.String() != "589" {
+		t.Fatalf("Tom should not be updated")
+	}
+	//数据源中不存在的key版本号是0
+	if _, err := g.CompareAndSet(ctx, "Jack", 0, []byte("1")); err != nil {
+		t.Fatalf("cas on absent key should succeed: %v", err)
+	}
+	if _, err := g.CompareAndSet(ctx, "Sam", 1, []byte("1")); !errors.Is(err, ErrConflict) {
+		t.Fatalf("cas on absent key should conflict, got %v", err)
+	}
+
+	//并发自增,每次都读取最新值之后重试,最后的结果是准确的
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; {
+				v, meta, err := g.GetWithMeta("Tom")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				i, _ := strconv.Atoi(v.String())
+				if _, err := g.CompareAndSet(ctx, "Tom", meta.Version, []byte(strconv.Itoa(i+1))); err == nil {
+					n++
+				} else if !errors.Is(err, ErrConflict) {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := g.Get("Tom"); v.String() != strconv.Itoa(589+8*50) {
+		t.Fatalf("expect %d, but got %s", 589+8*50, v.String())
+	}
+}
@@ -0,0 +1,138 @@
+package gacache
+
+import (
+	"bufio"
+	"fmt"
+	pb "gacache/gacachepb"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//通过load预先加载keys,concurrency限制同时加载的key的数量,小于1的时候当作1
+//只加载属于当前节点的key(其他节点的key由它们自己预热),已经在mainCache中的key会跳过
+//返回成功加载的数量,加载失败不会中断预热,返回最后一个错误
+func (g *Group) WarmUp(keys []string, concurrency int) (int, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		loaded  int
+		lastErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for _, key := range keys {
+		if key == "" || !g.owns(key) {
+			continue
+		}
+		if _, ok := g.mainCache.get(key); ok {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			_, err := g.load(key)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lastErr = fmt.Errorf("warm up %s: %v", key, err)
+				return
+			}
+			loaded++
+		}(key)
+	}
+	wg.Wait()
+	return loaded, lastErr
+}
+
+//key是否属于当前节点,没有注册PeerPicker的时候所有key都属于当前节点
+func (g *Group) owns(key string) bool {
+	if g.peers == nil {
+		return true
+	}
+	_, ok := g.peers.PickPeer(key)
+	return !ok
+}
+
+//从文件中读取需要预热的key,每行一个,忽略空行和#开头的注释
+func KeysFromFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, s.Err()
+}
+
+//导出mainCache中没有过期的数据,hotCache中是其他节点的数据,不需要导出
+//keep不为nil的时候只导出它返回true的key,分块存储的value拼接之后导出,chunk已经被淘汰的跳过
+func (g *Group) dump(keep func(key string) bool) *pb.Entries {
+	type kv struct {
+		key   string
+		value ByteView
+	}
+	var views []kv
+	g.mainCache.rangeEntries(func(key string, v ByteView) bool {
+		if g.opts.chunkSize > 0 && isChunkKey(key) {
+			return true
+		}
+		if keep == nil || keep(key) {
+			views = append(views, kv{key, v})
+		}
+		return true
+	})
+	//在cache的锁外面拼接和解压
+	out := &pb.Entries{}
+	for _, kv := range views {
+		v, ok := g.resolve(kv.key, kv.value, &g.mainCache)
+		if !ok {
+			continue
+		}
+		v, err := v.decode()
+		if err != nil {
+			continue
+		}
+		e := &pb.Entry{Key: kv.key, Value: v.b, Version: v.v}
+		if !v.e.IsZero() {
+			e.Expire = v.e.UnixNano()
+		}
+		out.Entries = append(out.Entries, e)
+	}
+	return out
+}
+
+//将其他节点迁移过来的数据放入mainCache,已经存在的key不会覆盖
+//返回是否写入
+func (g *Group) fill(e *pb.Entry) bool {
+	if e.Key == "" {
+		return false
+	}
+	if _, ok := g.mainCache.get(e.Key); ok {
+		return false
+	}
+	v := ByteView{b: cloneBytes(e.Value), v: e.Version}
+	if e.Expire != 0 {
+		v.e = time.Unix(0, e.Expire)
+		if v.expired(g.opts.now()) {
+			return false
+		}
+	}
+	g.putCache(e.Key, v, &g.mainCache)
+	return true
+}
@@ -4,8 +4,14 @@ import (
 	"flag"
 	"fmt"
 	"gacache"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var db = map[string]string{
@@ -14,71 +20,238 @@ var db = map[string]string{
 	"jerry":   "119",
 }
 
-func creatGroup() *gacache.Group {
-	return gacache.NewGroup("scores", 2<<10, gacache.GetterFunc(
-		func(key string) ([]byte, error) {
-			log.Println("[SlowDB] search key", key)
-			if v, ok := db[key]; ok {
-				return []byte(v), nil
-			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+//没有配置文件时使用的默认配置
+func defaultConfig(port int, api bool) *Config {
+	addrMap := map[int]string{
+		8001: "http://localhost:8001",
+		8002: "http://localhost:8002",
+		8003: "http://localhost:8003",
+		8004: "http://localhost:8004",
+	}
+	c := &Config{
+		Self: addrMap[port],
+		Groups: []GroupConfig{{
+			Name:       "scores",
+			CacheBytes: 2 << 10,
+			Source:     SourceConfig{Type: "map", Data: db},
+		}},
+	}
+	for _, v := range addrMap {
+		c.Peers = append(c.Peers, v)
+	}
+	if api {
+		c.API = "http://localhost:9999" //带api参数的就是本机self
+	}
+	return c
 }
 
-//启动缓存服务
-func startCacheServer(addr string, addrs []string, gac *gacache.Group) {
-	//创建节点Http服务端(实现了Picker接口)
+//根据配置创建所有的Group
+func createGroups(c *Config) ([]*gacache.Group, error) {
+	var gs []*gacache.Group
+	for _, gc := range c.Groups {
+		opts, err := gc.options()
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %v", gc.Name, err)
+		}
+		getter, err := newGetter(gc.Source)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %v", gc.Name, err)
+		}
+		g, err := gacache.NewGroupWithOptions(gc.Name, gc.CacheBytes, getter, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %v", gc.Name, err)
+		}
+		gs = append(gs, g)
+	}
+	return gs, nil
+}
+
+//创建节点Http服务端(实现了Picker接口)
+func newPeers(addr string, addrs []string, gs []*gacache.Group) *gacache.HTTPPool {
 	peers := gacache.NewHTTPPool(addr)
 	//将所有节点信息存入
 	peers.Set(addrs...)
 	//将当前Picker注册进入当前的节点(每个节点只有一个Picker)
-	gac.RegisterPeers(peers)
+	for _, gac := range gs {
+		gac.RegisterPeers(peers)
+	}
+	return peers
+}
+
+//预热的并发数
+const warmUpConcurrency = 8
+
+//在接收请求之前预热缓存,先从其他节点迁移现在属于当前节点的数据,再从数据源加载key列表文件中剩下的key
+func warmUp(c *Config, peers *gacache.HTTPPool, gs []*gacache.Group, fromPeers bool) {
+	for i, g := range gs {
+		if fromPeers {
+			n, err := peers.WarmUpFromPeers(g)
+			log.Printf("group %s: %d keys from peers, err: %v", g.Name(), n, err)
+		}
+		if path := c.Groups[i].WarmUp; path != "" {
+			keys, err := gacache.KeysFromFile(path)
+			if err != nil {
+				log.Printf("group %s: warm up: %v", g.Name(), err)
+				continue
+			}
+			n, err := g.WarmUp(keys, warmUpConcurrency)
+			log.Printf("group %s: %d keys from source, err: %v", g.Name(), n, err)
+		}
+	}
+}
+
+//启动缓存服务
+func startCacheServer(addr string, peers *gacache.HTTPPool) {
 	log.Println("gacache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	log.Fatal(http.ListenAndServe(hostOf(addr), peers))
 }
 
 //与用户交互的server
-func startAPIServer(apiAddr string, gac *gacache.Group) {
+//请求格式 /api?group=scores&key=tom,只有一个Group的时候可以省略group
+//PUT请求写入body,由key所属的节点更新缓存
+func startAPIServer(apiAddr string) {
 	http.Handle("/api", http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			var gac *gacache.Group
+			if name := r.URL.Query().Get("group"); name != "" {
+				gac = gacache.GetGroup(name)
+			} else if gs := gacache.ListGroups(); len(gs) == 1 {
+				gac = gs[0]
+			} else {
+				http.Error(w, "group required", http.StatusBadRequest)
+				return
+			}
+			if gac == nil {
+				http.Error(w, "no such group", http.StatusNotFound)
+				return
+			}
 			key := r.URL.Query().Get("key")
-			view, err := gac.Get(key)
+			if r.Method == http.MethodPut {
+				value, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				//带If-Match的时候只有版本号一致才写入
+				var version uint64
+				if match := r.Header.Get("If-Match"); match != "" {
+					expected, perr := strconv.ParseUint(strings.Trim(match, `"`), 10, 64)
+					if perr != nil {
+						http.Error(w, "invalid If-Match", http.StatusBadRequest)
+						return
+					}
+					version, err = gac.CompareAndSet(r.Context(), key, expected, value)
+				} else {
+					err = gac.Set(r.Context(), key, value)
+				}
+				if err != nil {
+					http.Error(w, err.Error(), gacache.HTTPStatus(err))
+					return
+				}
+				if version != 0 {
+					w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(version, 10)))
+				}
+				return
+			}
+			//分块存储的大value逐块返回,不需要拼接成完整的切片
+			body, meta, err := gac.GetReaderWithMeta(key)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				http.Error(w, err.Error(), gacache.HTTPStatus(err))
 				return
 			}
+			if meta.Version != 0 {
+				tag := strconv.Quote(strconv.FormatUint(meta.Version, 10))
+				w.Header().Set("ETag", tag)
+				//客户端的副本没有变化的时候不返回body
+				if etagMatch(r.Header.Get("If-None-Match"), tag) {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
 			w.Header().Set("Content-Type", "application/octet-stream")
-			w.Write(view.ByteSlice())
-
+			io.Copy(w, body)
 		}))
 	log.Println("fontend server is running at", apiAddr)
-	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
+	log.Fatal(http.ListenAndServe(hostOf(apiAddr), nil))
+}
+
+//If-None-Match是否匹配tag,按照RFC 9110使用弱比较: 逗号分隔的列表中任意一个去掉W/之后和tag相同,或者是*
+func etagMatch(header, tag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	for header != "" {
+		header = strings.TrimLeft(header, " \t,")
+		header = strings.TrimPrefix(header, "W/")
+		if !strings.HasPrefix(header, `"`) {
+			return false
+		}
+		end := strings.IndexByte(header[1:], '"')
+		if end < 0 {
+			return false
+		}
+		if header[:end+2] == tag {
+			return true
+		}
+		header = header[end+2:]
+	}
+	return false
+}
+
+//http://localhost:8001 => localhost:8001
+func hostOf(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return addr
+	}
+	return u.Host
 }
 
 func main() {
 	var port int
-	var api bool
+	var api, fromPeers bool
+	var configPath, self, snapshotDir string
+	var watch, snapshotInterval time.Duration
 	//命令行解析
 	flag.IntVar(&port, "port", 8001, "Gacache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.StringVar(&configPath, "config", "", "Cluster config file, -port and -api are ignored when set")
+	flag.StringVar(&self, "self", "", "Override self address of the config file")
+	flag.DurationVar(&watch, "watch", 0, "Interval to check the config file for changes, 0 means SIGHUP only")
+	flag.StringVar(&snapshotDir, "snapshot", "", "Directory to save snapshots in and restore them from on boot")
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", 5*time.Minute, "Interval between snapshots")
+	flag.BoolVar(&fromPeers, "warmup-peers", false, "Pull the keys owned by this node from other peers before serving")
 	flag.Parse()
-	//与用户交互的server
-	apiAddr := "http://localhost:9999"
-	addrMap := map[int]string{
-		8001: "http://localhost:8001",
-		8002: "http://localhost:8002",
-		8003: "http://localhost:8003",
-		8004: "http://localhost:8004",
+	conf := defaultConfig(port, api)
+	if configPath != "" {
+		var err error
+		if conf, err = loadConfig(configPath, self); err != nil {
+			log.Fatal(err)
+		}
 	}
-	var addrs []string
-	for _, v := range addrMap {
-		addrs = append(addrs, v)
+	gs, err := createGroups(conf)
+	if err != nil {
+		log.Fatal(err)
 	}
-	gac := creatGroup()
-	if api {
-		go startAPIServer(apiAddr, gac) //带api参数的就是本机self
+	peers := newPeers(conf.Self, conf.Peers, gs)
+	//数据变化之后通过长轮询通知其他节点删除hotCache中的副本
+	gacache.RegisterBroker(peers)
+	if snapshotDir != "" {
+		restoreSnapshots(snapshotDir, gs)
+	}
+	warmUp(conf, peers, gs, fromPeers)
+	if snapshotDir != "" && snapshotInterval > 0 {
+		//热加载可能会增删Group,每次都重新获取
+		go snapshotLoop(snapshotDir, snapshotInterval, gacache.ListGroups)
+	}
+	if conf.API != "" {
+		go startAPIServer(conf.API)
+	}
+	if configPath != "" {
+		go newReloader(configPath, self, conf, peers, gs).watch(watch)
 	}
-	startCacheServer(addrMap[port], addrs, gac)
+	startCacheServer(conf.Self, peers)
 }
 
 //http服务端测试
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestETagMatch(t *testing.T) {
+	const tag = `"630"`
+	testCase := []struct {
+		header string
+		expect bool
+	}{
+		{``, false},
+		{`"630"`, true},
+		{`"589"`, false},
+		{`*`, true},
+		{`W/"630"`, true},
+		{`"589", "630"`, true},
+		{`"589",W/"630"`, true},
+		{`W/"589", W/"0"`, false},
+		{`"5,89", "630"`, true},
+		{`"630`, false},
+		{`630`, false},
+	}
+	for _, c := range testCase {
+		if got := etagMatch(c.header, tag); got != c.expect {
+			t.Errorf("If-None-Match %s should match %v, but got %v", c.header, c.expect, got)
+		}
+	}
+}
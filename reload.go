@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"gacache"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//配置文件热加载,节点变化时更新一致性Hash环,Group大小变化时直接调整,不会丢弃已有的缓存
+type reloader struct {
+	mu     sync.Mutex
+	path   string //配置文件路径
+	self   string //命令行指定的self
+	conf   *Config
+	peers  *gacache.HTTPPool
+	groups map[string]*gacache.Group
+}
+
+func newReloader(path, self string, conf *Config, peers *gacache.HTTPPool, gs []*gacache.Group) *reloader {
+	r := &reloader{
+		path:   path,
+		self:   self,
+		conf:   conf,
+		peers:  peers,
+		groups: make(map[string]*gacache.Group, len(gs)),
+	}
+	for _, g := range gs {
+		r.groups[g.Name()] = g
+	}
+	return r
+}
+
+//重新加载配置文件,配置不合法的时候保留旧的配置
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	conf, err := loadConfig(r.path, r.self)
+	if err != nil {
+		return err
+	}
+	changes := diffConfig(r.conf, conf)
+	if len(changes) == 0 {
+		return nil
+	}
+	for _, c := range changes {
+		log.Println("[Reload]", c)
+	}
+	if err := r.apply(conf); err != nil {
+		return err
+	}
+	r.conf = effectiveConfig(r.conf, conf)
+	return nil
+}
+
+//应用新的配置,先创建新增的Group,再调整已有Group的大小,其中任何一步失败都回滚已经做的修改
+//之后更新节点列表和删除Group,这两步不会失败,保证节点不会停留在一半新一半旧的配置上
+//回滚只恢复Group的大小,缩小时已经逐出的数据不会恢复
+func (r *reloader) apply(conf *Config) (err error) {
+	var created []*gacache.Group
+	resized := make(map[string]int64) //已经调整过大小的Group原来的cache_bytes
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, g := range created {
+			gacache.DeleteGroup(g.Name())
+		}
+		for name, cacheBytes := range resized {
+			if e := r.groups[name].SetCacheBytes(cacheBytes); e != nil {
+				log.Printf("[Reload] fail to restore group %s: %v", name, e)
+			}
+		}
+	}()
+	olds := make(map[string]int64, len(r.conf.Groups))
+	for _, gc := range r.conf.Groups {
+		olds[gc.Name] = gc.CacheBytes
+	}
+	for _, gc := range conf.Groups {
+		if _, ok := r.groups[gc.Name]; ok {
+			continue
+		}
+		//新增的Group
+		gs, err := createGroups(&Config{Groups: []GroupConfig{gc}})
+		if err != nil {
+			return err
+		}
+		created = append(created, gs[0])
+	}
+	for _, gc := range conf.Groups {
+		g, ok := r.groups[gc.Name]
+		if !ok || gc.CacheBytes == olds[gc.Name] || !resizable(olds[gc.Name], gc.CacheBytes) {
+			continue
+		}
+		if err := g.SetCacheBytes(gc.CacheBytes); err != nil {
+			return fmt.Errorf("group %s: %v", gc.Name, err)
+		}
+		resized[gc.Name] = olds[gc.Name]
+	}
+
+	for _, g := range created {
+		g.RegisterPeers(r.peers)
+		r.groups[g.Name()] = g
+	}
+	if added, removed := diffStrings(r.conf.Peers, conf.Peers); len(added)+len(removed) > 0 {
+		r.peers.Set(conf.Peers...)
+	}
+	//删除的Group
+	names := make(map[string]bool, len(conf.Groups))
+	for _, gc := range conf.Groups {
+		names[gc.Name] = true
+	}
+	for name := range r.groups {
+		if !names[name] {
+			gacache.DeleteGroup(name)
+			delete(r.groups, name)
+		}
+	}
+	return nil
+}
+
+//cache_bytes为0代表不限制,运行时只能在限制的大小之间调整,不能和不限制互相切换
+func resizable(old, new int64) bool {
+	return old > 0 && new > 0
+}
+
+//应用new之后实际生效的配置,需要重启才能生效的字段保留old中的值
+//这样之后的热加载依然会提示这些字段需要重启
+func effectiveConfig(old, new *Config) *Config {
+	c := *new
+	c.Self, c.API = old.Self, old.API
+	olds := make(map[string]GroupConfig, len(old.Groups))
+	for _, g := range old.Groups {
+		olds[g.Name] = g
+	}
+	c.Groups = make([]GroupConfig, len(new.Groups))
+	for i, g := range new.Groups {
+		if o, ok := olds[g.Name]; ok {
+			//已有的Group只有cache_bytes可以在运行时修改
+			if resizable(o.CacheBytes, g.CacheBytes) {
+				o.CacheBytes = g.CacheBytes
+			}
+			g = o
+		}
+		c.Groups[i] = g
+	}
+	return &c
+}
+
+//收到SIGHUP或者配置文件修改时间变化的时候重新加载,interval为0时不检查修改时间
+func (r *reloader) watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	modTime := r.modTime()
+	for {
+		select {
+		case <-hup:
+			log.Println("[Reload] SIGHUP received, reloading", r.path)
+		case <-tick:
+			t := r.modTime()
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+			log.Println("[Reload] config changed, reloading", r.path)
+		}
+		if err := r.reload(); err != nil {
+			log.Println("[Reload] keep the old config:", err)
+		}
+	}
+}
+
+func (r *reloader) modTime() time.Time {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+//对比新旧配置,返回可读的变更列表
+func diffConfig(old, new *Config) []string {
+	var changes []string
+	if old.Self != new.Self {
+		changes = append(changes, fmt.Sprintf("self: %s -> %s (requires restart)", old.Self, new.Self))
+	}
+	if old.API != new.API {
+		changes = append(changes, fmt.Sprintf("api: %s -> %s (requires restart)", old.API, new.API))
+	}
+	added, removed := diffStrings(old.Peers, new.Peers)
+	for _, p := range added {
+		changes = append(changes, "peers: +"+p)
+	}
+	for _, p := range removed {
+		changes = append(changes, "peers: -"+p)
+	}
+	olds := make(map[string]GroupConfig, len(old.Groups))
+	for _, g := range old.Groups {
+		olds[g.Name] = g
+	}
+	for _, g := range new.Groups {
+		o, ok := olds[g.Name]
+		if !ok {
+			changes = append(changes, "groups: +"+g.Name)
+			continue
+		}
+		delete(olds, g.Name)
+		if o.CacheBytes != g.CacheBytes {
+			change := fmt.Sprintf("group %s: cache_bytes %d -> %d", g.Name, o.CacheBytes, g.CacheBytes)
+			if !resizable(o.CacheBytes, g.CacheBytes) {
+				change += " (requires restart)"
+			}
+			changes = append(changes, change)
+		}
+		if o.TTL != g.TTL || o.StaleTTL != g.StaleTTL || o.Policy != g.Policy || o.Storage != g.Storage || !reflect.DeepEqual(o.HotCacheRatio, g.HotCacheRatio) {
+			changes = append(changes, fmt.Sprintf("group %s: ttl/policy/storage/hot_cache_ratio changed (requires restart)", g.Name))
+		}
+		if o.Compression != g.Compression || o.CompressMin != g.CompressMin || o.ChunkSize != g.ChunkSize || o.MaxEntryBytes != g.MaxEntryBytes || o.EntryOverhead != g.EntryOverhead {
+			changes = append(changes, fmt.Sprintf("group %s: compression/chunk_size/max_entry_bytes/entry_overhead changed (requires restart)", g.Name))
+		}
+		if o.DiskDir != g.DiskDir || o.DiskBytes != g.DiskBytes {
+			changes = append(changes, fmt.Sprintf("group %s: disk cache changed (requires restart)", g.Name))
+		}
+		if !reflect.DeepEqual(o.Source, g.Source) {
+			changes = append(changes, fmt.Sprintf("group %s: source changed (requires restart)", g.Name))
+		}
+	}
+	var rest []string
+	for name := range olds {
+		rest = append(rest, name)
+	}
+	sort.Strings(rest)
+	for _, name := range rest {
+		changes = append(changes, "groups: -"+name)
+	}
+	return changes
+}
+
+//a到b新增和删除的元素
+func diffStrings(a, b []string) (added, removed []string) {
+	in := func(s string, ss []string) bool {
+		for _, v := range ss {
+			if v == s {
+				return true
+			}
+		}
+		return false
+	}
+	for _, s := range b {
+		if !in(s, a) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range a {
+		if !in(s, b) {
+			removed = append(removed, s)
+		}
+	}
+	return
+}
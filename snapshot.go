@@ -0,0 +1,65 @@
+package main
+
+import (
+	"gacache"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//每个Group的快照保存在dir/<group>.snapshot
+func snapshotPath(dir, group string) string {
+	return filepath.Join(dir, group+".snapshot")
+}
+
+//启动时从快照中恢复,快照不存在的Group直接跳过
+func restoreSnapshots(dir string, gs []*gacache.Group) {
+	for _, g := range gs {
+		f, err := os.Open(snapshotPath(dir, g.Name()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Printf("group %s: restore: %v", g.Name(), err)
+			continue
+		}
+		n, err := g.Restore(f)
+		f.Close()
+		log.Printf("group %s: %d keys from snapshot, err: %v", g.Name(), n, err)
+	}
+}
+
+//先写入临时文件再重命名,避免写到一半的时候进程退出破坏已有的快照
+func saveSnapshot(dir string, g *gacache.Group) (int, error) {
+	f, err := ioutil.TempFile(dir, g.Name()+".snapshot.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	n, err := g.Snapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, os.Rename(f.Name(), snapshotPath(dir, g.Name()))
+}
+
+//每隔interval保存一次所有Group的快照
+func snapshotLoop(dir string, interval time.Duration, groups func() []*gacache.Group) {
+	for range time.Tick(interval) {
+		for _, g := range groups() {
+			if n, err := saveSnapshot(dir, g); err != nil {
+				log.Printf("group %s: snapshot: %v", g.Name(), err)
+			} else {
+				log.Printf("group %s: %d keys saved to snapshot", g.Name(), n)
+			}
+		}
+	}
+}
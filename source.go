@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gacache"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+//根据数据源配置创建Getter
+func newGetter(s SourceConfig) (gacache.Getter, error) {
+	switch s.Type {
+	case "map":
+		return mapGetter(s.Data), nil
+	case "file":
+		//文件内容是一个key-value的JSON对象,启动时一次性读入
+		b, err := ioutil.ReadFile(s.Path)
+		if err != nil {
+			return nil, fmt.Errorf("read source file: %v", err)
+		}
+		data := make(map[string]string)
+		if err := json.Unmarshal(b, &data); err != nil {
+			return nil, fmt.Errorf("parse source file %s: %v", s.Path, err)
+		}
+		return mapGetter(data), nil
+	case "http":
+		return httpSource(s.URL), nil
+	}
+	return nil, fmt.Errorf("unknown source type %q", s.Type)
+}
+
+func mapGetter(data map[string]string) gacache.Getter {
+	return gacache.GetterFunc(func(key string) ([]byte, error) {
+		log.Println("[SlowDB] search key", key)
+		if v, ok := data[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, gacache.NewError(gacache.CodeNotFound, "%s not exist", key)
+	})
+}
+
+//通过http请求数据源,请求地址为url+key
+func httpSource(base string) gacache.Getter {
+	return gacache.GetterFunc(func(key string) ([]byte, error) {
+		res, err := http.Get(base + url.QueryEscape(key))
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusNotFound {
+			return nil, gacache.NewError(gacache.CodeNotFound, "%s not exist", key)
+		}
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("source returned: %v", res.Status)
+		}
+		return ioutil.ReadAll(res.Body)
+	})
+}